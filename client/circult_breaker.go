@@ -15,6 +15,7 @@
 package client
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/kitex-contrib/config-consul/consul"
@@ -28,27 +29,48 @@ import (
 
 // WithCircuitBreaker sets the circuit breaker policy from consul configuration center.
 func WithCircuitBreaker(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) []client.Option {
-	param, err := consulClient.ClientConfigParam(&consul.ConfigParamConfig{
-		Category:          circuitBreakerConfigName,
-		ServerServiceName: dest,
-		ClientServiceName: src,
-	})
+	param, err := configParam(circuitBreakerConfigName, dest, src, consulClient, opts)
 	if err != nil {
 		panic(err)
 	}
+	key := param.ID()
+	cbSuite, err := initCircuitBreaker(param.Type, key, dest, src, consulClient, uniqueID, opts)
+	if errors.Is(err, utils.ErrInitialConfigTimeout) {
+		klog.Warnf("[consul] %s client consul circuit breaker: %s, continue with the default config", key, err)
+	}
+	return circuitBreakerOptions(key, consulClient, uniqueID, cbSuite)
+}
+
+// WithCircuitBreakerE is the error-returning version of WithCircuitBreaker, the template rendering,
+// consul connectivity and initial decode errors are returned instead of panicking or logging.
+func WithCircuitBreakerE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, error) {
+	options, _, err := circuitBreakerE(dest, src, consulClient, uniqueID, opts)
+	return options, err
+}
 
-	for _, f := range opts.ConsulCustomFunctions {
-		f(&param)
+// circuitBreakerE is like WithCircuitBreakerE, and returns the Close of the circuit breaker suite as well.
+func circuitBreakerE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, func() error, error) {
+	param, err := configParam(circuitBreakerConfigName, dest, src, consulClient, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := param.ID()
+	cbSuite, err := initCircuitBreaker(param.Type, key, dest, src, consulClient, uniqueID, opts)
+	if err != nil {
+		consulClient.DeregisterConfig(key, uniqueID)
+		cbSuite.Close()
+		return nil, nil, err
 	}
-	key := param.Prefix + "/" + param.Path
-	cbSuite := initCircuitBreaker(param.Type, key, dest, src, consulClient, uniqueID)
+	return circuitBreakerOptions(key, consulClient, uniqueID, cbSuite), cbSuite.Close, nil
+}
 
+func circuitBreakerOptions(key string, consulClient consul.Client, uniqueID int64, cbSuite *circuitbreak.CBSuite) []client.Option {
 	return []client.Option{
 		client.WithCircuitBreaker(cbSuite),
 		client.WithCloseCallbacks(func() error {
 			// cancel the configuration listener when client is closed.
 			consulClient.DeregisterConfig(key, uniqueID)
-			err = cbSuite.Close()
+			err := cbSuite.Close()
 			if err != nil {
 				return err
 			}
@@ -77,17 +99,36 @@ func genServiceCBKey(toService, method string) string {
 }
 
 func initCircuitBreaker(configType consul.ConfigType, key, dest, src string,
-	consulClient consul.Client, uniqueID int64,
-) *circuitbreak.CBSuite {
+	consulClient consul.Client, uniqueID int64, opts utils.Options,
+) (*circuitbreak.CBSuite, error) {
 	cb := circuitbreak.NewCBSuite(genServiceCBKeyWithRPCInfo)
 	lcb := utils.ThreadSafeSet{}
+	result := &utils.InitResult{}
+	observer := consulClient.Observer()
 
-	onChangeCallback := func(data string, parser consul.ConfigParser) {
+	onChangeCallback := func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Deleted {
+			// the missing key is loaded with the default config.
+			result.Record(nil)
+			if opts.DeletePolicy == utils.KeepLastKnownGood {
+				klog.Infof("[consul] %s client consul circuit breaker: key deleted, keep the last config", key)
+				return
+			}
+			for _, method := range lcb.DiffAndEmplace(utils.Set{}) {
+				// reset all the method configs to default policy
+				cb.UpdateServiceCBConfig(genServiceCBKey(dest, method), circuitbreak.GetDefaultCBConfig())
+			}
+			observer.OnApplied(key, circuitBreakerConfigName, event.ModifyIndex, 0)
+			return
+		}
+		data := event.Value
 		set := utils.Set{}
 		configs := map[string]circuitbreak.CBConfig{}
 		err := parser.Decode(configType, data, &configs)
+		result.Record(err)
 		if err != nil {
 			klog.Warnf("[consul] %s client consul circuit breaker: unmarshal data %s failed: %s, skip...", key, data, err)
+			observer.OnDecodeError(key, circuitBreakerConfigName, err)
 			return
 		}
 
@@ -102,9 +143,9 @@ func initCircuitBreaker(configType consul.ConfigType, key, dest, src string,
 			// For deleted method configs, set to default policy
 			cb.UpdateServiceCBConfig(key, circuitbreak.GetDefaultCBConfig())
 		}
+		observer.OnApplied(key, circuitBreakerConfigName, event.ModifyIndex, len(configs))
 	}
 
-	consulClient.RegisterConfigCallback(key, uniqueID, onChangeCallback)
-
-	return cb
+	err := consulClient.RegisterConfigEventCallback(key, uniqueID, onChangeCallback)
+	return cb, utils.InitError(key, result, err, opts)
 }
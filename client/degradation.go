@@ -15,6 +15,7 @@
 package client
 
 import (
+	"errors"
 	"github.com/cloudwego/kitex/client"
 	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/kitex-contrib/config-consul/consul"
@@ -22,20 +23,37 @@ import (
 	"github.com/kitex-contrib/config-consul/utils"
 )
 
+// WithDegradation sets the degradation policy from consul configuration center.
 func WithDegradation(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) []client.Option {
-	param, err := consulClient.ClientConfigParam(&consul.ConfigParamConfig{
-		Category:          degradationConfigName,
-		ServerServiceName: dest,
-		ClientServiceName: src,
-	})
+	param, err := configParam(degradationConfigName, dest, src, consulClient, opts)
 	if err != nil {
 		panic(err)
 	}
-	for _, f := range opts.ConsulCustomFunctions {
-		f(&param)
+	key := param.ID()
+	container, err := initDegradationOptions(param.Type, key, dest, uniqueID, consulClient, opts)
+	if errors.Is(err, utils.ErrInitialConfigTimeout) {
+		klog.Warnf("[consul] %s client consul degradation: %s, continue with the default config", key, err)
 	}
-	key := param.Prefix + "/" + param.Path
-	container := initDegradationOptions(param.Type, key, dest, uniqueID, consulClient)
+	return degradationOptions(key, consulClient, uniqueID, container)
+}
+
+// WithDegradationE is the error-returning version of WithDegradation, the template rendering,
+// consul connectivity and initial decode errors are returned instead of panicking or logging.
+func WithDegradationE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, error) {
+	param, err := configParam(degradationConfigName, dest, src, consulClient, opts)
+	if err != nil {
+		return nil, err
+	}
+	key := param.ID()
+	container, err := initDegradationOptions(param.Type, key, dest, uniqueID, consulClient, opts)
+	if err != nil {
+		consulClient.DeregisterConfig(key, uniqueID)
+		return nil, err
+	}
+	return degradationOptions(key, consulClient, uniqueID, container), nil
+}
+
+func degradationOptions(key string, consulClient consul.Client, uniqueID int64, container *degradation.DegradationContainer) []client.Option {
 	return []client.Option{
 		client.WithACLRules(container.GetAclRule()),
 		client.WithCloseCallbacks(func() error {
@@ -46,17 +64,38 @@ func WithDegradation(dest, src string, consulClient consul.Client, uniqueID int6
 	}
 }
 
-func initDegradationOptions(configType consul.ConfigType, key, dest string, uniqueID int64, consulClient consul.Client) *degradation.DegradationContainer {
+func initDegradationOptions(configType consul.ConfigType, key, dest string, uniqueID int64, consulClient consul.Client, opts utils.Options) (*degradation.DegradationContainer, error) {
 	container := degradation.NewDegradationContainer()
-	onChangeCallback := func(data string, parser consul.ConfigParser) {
+	result := &utils.InitResult{}
+	observer := consulClient.Observer()
+	onChangeCallback := func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Deleted {
+			// the missing key is loaded with the default config.
+			result.Record(nil)
+			if opts.DeletePolicy == utils.KeepLastKnownGood {
+				klog.Infof("[consul] %s client consul degradation: key deleted, keep the last config", key)
+				return
+			}
+			container.NotifyPolicyChange(&degradation.DegradationConfig{Enable: false})
+			observer.OnApplied(key, degradationConfigName, event.ModifyIndex, 0)
+			return
+		}
+		data := event.Value
 		config := &degradation.DegradationConfig{}
 		err := parser.Decode(configType, data, config)
+		result.Record(err)
 		if err != nil {
 			klog.Warnf("[consul] %s server consul degradation config: unmarshal data %s failed: %s, skip...", key, data, err)
+			observer.OnDecodeError(key, degradationConfigName, err)
 			return
 		}
 		container.NotifyPolicyChange(config)
+		policies := 0
+		if config.Enable {
+			policies = 1
+		}
+		observer.OnApplied(key, degradationConfigName, event.ModifyIndex, policies)
 	}
-	consulClient.RegisterConfigCallback(key, uniqueID, onChangeCallback)
-	return container
+	err := consulClient.RegisterConfigEventCallback(key, uniqueID, onChangeCallback)
+	return container, utils.InitError(key, result, err, opts)
 }
@@ -15,6 +15,9 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/kitex-contrib/config-consul/consul"
 	"github.com/kitex-contrib/config-consul/utils"
 
@@ -25,20 +28,42 @@ import (
 
 // WithRetryPolicy sets the retry policy from consul configuration center.
 func WithRetryPolicy(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) []client.Option {
-	param, err := consulClient.ClientConfigParam(&consul.ConfigParamConfig{
-		Category:          retryConfigName,
-		ServerServiceName: dest,
-		ClientServiceName: src,
-	})
+	param, err := configParam(retryConfigName, dest, src, consulClient, opts)
 	if err != nil {
 		panic(err)
 	}
+	key := param.ID()
+	rc, err := initRetryContainer(param.Type, key, dest, consulClient, uniqueID, opts)
+	if errors.Is(err, utils.ErrInitialConfigTimeout) {
+		klog.Warnf("[consul] %s client consul retry: %s, continue with the default config", key, err)
+	}
+	return retryOptions(key, consulClient, uniqueID, rc)
+}
+
+// WithRetryPolicyE is the error-returning version of WithRetryPolicy, the template rendering,
+// consul connectivity and initial decode errors are returned instead of panicking or logging.
+func WithRetryPolicyE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, error) {
+	options, _, err := retryPolicyE(dest, src, consulClient, uniqueID, opts)
+	return options, err
+}
 
-	for _, f := range opts.ConsulCustomFunctions {
-		f(&param)
+// retryPolicyE is like WithRetryPolicyE, and returns the Close of the retry container as well.
+func retryPolicyE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, func() error, error) {
+	param, err := configParam(retryConfigName, dest, src, consulClient, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := param.ID()
+	rc, err := initRetryContainer(param.Type, key, dest, consulClient, uniqueID, opts)
+	if err != nil {
+		consulClient.DeregisterConfig(key, uniqueID)
+		rc.Close()
+		return nil, nil, err
 	}
-	key := param.Prefix + "/" + param.Path
-	rc := initRetryContainer(param.Type, key, dest, consulClient, uniqueID)
+	return retryOptions(key, consulClient, uniqueID, rc), rc.Close, nil
+}
+
+func retryOptions(key string, consulClient consul.Client, uniqueID int64, rc *retry.Container) []client.Option {
 	return []client.Option{
 		client.WithRetryContainer(rc),
 		client.WithCloseCallbacks(func() error {
@@ -51,37 +76,59 @@ func WithRetryPolicy(dest, src string, consulClient consul.Client, uniqueID int6
 }
 
 func initRetryContainer(configType consul.ConfigType, key, dest string,
-	consulClient consul.Client, uniqueID int64,
-) *retry.Container {
+	consulClient consul.Client, uniqueID int64, opts utils.Options,
+) (*retry.Container, error) {
 	retryContainer := retry.NewRetryContainerWithPercentageLimit()
 
 	ts := utils.ThreadSafeSet{}
+	result := &utils.InitResult{}
+	observer := consulClient.Observer()
 
-	onChangeCallback := func(data string, parser consul.ConfigParser) {
+	onChangeCallback := func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Deleted {
+			// the missing key is loaded with the default config.
+			result.Record(nil)
+			if opts.DeletePolicy == utils.KeepLastKnownGood {
+				klog.Infof("[consul] %s client consul retry: key deleted, keep the last config", key)
+				return
+			}
+			for _, method := range ts.DiffAndEmplace(utils.Set{}) {
+				retryContainer.DeletePolicy(method)
+			}
+			observer.OnApplied(key, retryConfigName, event.ModifyIndex, 0)
+			return
+		}
+		data := event.Value
 		// the key is method name, wildcard "*" can match anything.
 		rcs := map[string]*retry.Policy{}
 		err := parser.Decode(configType, data, &rcs)
+		result.Record(err)
 		if err != nil {
 			klog.Warnf("[consul] %s client consul retry: unmarshal data %s failed: %s, skip...", key, data, err)
+			observer.OnDecodeError(key, retryConfigName, err)
 			return
 		}
 		set := utils.Set{}
+		policies := 0
 		for method, policy := range rcs {
 			set[method] = true
 			if policy.Enable && policy.BackupPolicy == nil && policy.FailurePolicy == nil {
 				klog.Warnf("[consul] %s client policy for method %s BackupPolicy and FailurePolicy must not be empty at same time",
 					dest, method)
+				observer.OnApplyError(key, retryConfigName,
+					fmt.Errorf("policy for method %s: BackupPolicy and FailurePolicy must not be empty at same time", method))
 				continue
 			}
 			retryContainer.NotifyPolicyChange(method, *policy)
+			policies++
 		}
 
 		for _, method := range ts.DiffAndEmplace(set) {
 			retryContainer.DeletePolicy(method)
 		}
+		observer.OnApplied(key, retryConfigName, event.ModifyIndex, policies)
 	}
 
-	consulClient.RegisterConfigCallback(key, uniqueID, onChangeCallback)
-
-	return retryContainer
+	err := consulClient.RegisterConfigEventCallback(key, uniqueID, onChangeCallback)
+	return retryContainer, utils.InitError(key, result, err, opts)
 }
@@ -0,0 +1,65 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+	"github.com/kitex-contrib/config-consul/utils"
+)
+
+const retryKey = "KitexConfig/ClientName/ServiceName/retry"
+
+func TestRetryPolicy(t *testing.T) {
+	cli, err := consultest.NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+
+	cli.Set(retryKey, `{"*":{"enable":true,"type":0,"failure_policy":{"stop_policy":{"max_retry_times":2}}}}`)
+	rc, err := initRetryContainer(consul.JSON, retryKey, "ServiceName", cli, 1, utils.Options{})
+	test.Assert(t, err == nil)
+	dump := rc.Dump().(map[string]interface{})
+	_, ok := dump["*"]
+	test.Assert(t, ok, dump)
+
+	cli.Set(retryKey, `{"Echo":{"enable":true,"type":0,"failure_policy":{"stop_policy":{"max_retry_times":2}}}}`)
+	cli.Settle()
+	dump = rc.Dump().(map[string]interface{})
+	_, ok = dump["*"]
+	test.Assert(t, !ok, dump)
+	_, ok = dump["Echo"]
+	test.Assert(t, ok, dump)
+
+	cli.Delete(retryKey)
+	cli.Settle()
+	dump = rc.Dump().(map[string]interface{})
+	_, ok = dump["Echo"]
+	test.Assert(t, !ok, dump)
+
+	cli.Set(retryKey, "invalid")
+	rc, err = initRetryContainer(consul.JSON, retryKey, "ServiceName", cli, 2, utils.Options{})
+	test.Assert(t, err != nil)
+	rc.Close()
+
+	// the missing key is loaded with the default config instead of waiting for the timeout.
+	rc, err = initRetryContainer(consul.JSON, "KitexConfig/ClientName/Missing/retry", "Missing", cli, 3,
+		utils.Options{InitialConfigTimeout: time.Second, TimeoutPolicy: utils.FailFast})
+	test.Assert(t, err == nil, err)
+	rc.Close()
+}
@@ -15,6 +15,7 @@
 package client
 
 import (
+	"errors"
 	"github.com/kitex-contrib/config-consul/consul"
 	"github.com/kitex-contrib/config-consul/utils"
 
@@ -26,21 +27,37 @@ import (
 
 // WithRPCTimeout sets the RPC timeout policy from consul configuration center.
 func WithRPCTimeout(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) []client.Option {
-	param, err := consulClient.ClientConfigParam(&consul.ConfigParamConfig{
-		Category:          rpcTimeoutConfigName,
-		ServerServiceName: dest,
-		ClientServiceName: src,
-	})
+	param, err := configParam(rpcTimeoutConfigName, dest, src, consulClient, opts)
 	if err != nil {
 		panic(err)
 	}
+	key := param.ID()
+	tp, err := initRPCTimeoutContainer(param.Type, key, dest, consulClient, uniqueID, opts)
+	if errors.Is(err, utils.ErrInitialConfigTimeout) {
+		klog.Warnf("[consul] %s client consul rpc timeout: %s, continue with the default config", key, err)
+	}
+	return rpcTimeoutOptions(key, consulClient, uniqueID, tp)
+}
 
-	for _, f := range opts.ConsulCustomFunctions {
-		f(&param)
+// WithRPCTimeoutE is the error-returning version of WithRPCTimeout, the template rendering,
+// consul connectivity and initial decode errors are returned instead of panicking or logging.
+func WithRPCTimeoutE(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, error) {
+	param, err := configParam(rpcTimeoutConfigName, dest, src, consulClient, opts)
+	if err != nil {
+		return nil, err
+	}
+	key := param.ID()
+	tp, err := initRPCTimeoutContainer(param.Type, key, dest, consulClient, uniqueID, opts)
+	if err != nil {
+		consulClient.DeregisterConfig(key, uniqueID)
+		return nil, err
 	}
-	key := param.Prefix + "/" + param.Path
+	return rpcTimeoutOptions(key, consulClient, uniqueID, tp), nil
+}
+
+func rpcTimeoutOptions(key string, consulClient consul.Client, uniqueID int64, tp rpcinfo.TimeoutProvider) []client.Option {
 	return []client.Option{
-		client.WithTimeoutProvider(initRPCTimeoutContainer(param.Type, key, dest, consulClient, uniqueID)),
+		client.WithTimeoutProvider(tp),
 		client.WithCloseCallbacks(func() error {
 			// cancel the configuration listener when client is closed.
 			consulClient.DeregisterConfig(key, uniqueID)
@@ -50,22 +67,38 @@ func WithRPCTimeout(dest, src string, consulClient consul.Client, uniqueID int64
 }
 
 func initRPCTimeoutContainer(configType consul.ConfigType, key, dest string,
-	consulClient consul.Client, uniqueID int64,
-) rpcinfo.TimeoutProvider {
+	consulClient consul.Client, uniqueID int64, opts utils.Options,
+) (rpcinfo.TimeoutProvider, error) {
 	rpcTimeoutContainer := rpctimeout.NewContainer()
+	result := &utils.InitResult{}
+	observer := consulClient.Observer()
 
-	onChangeCallback := func(data string, parser consul.ConfigParser) {
+	onChangeCallback := func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Deleted {
+			// the missing key is loaded with the default config.
+			result.Record(nil)
+			if opts.DeletePolicy == utils.KeepLastKnownGood {
+				klog.Infof("[consul] %s client consul rpc timeout: key deleted, keep the last config", key)
+				return
+			}
+			rpcTimeoutContainer.NotifyPolicyChange(map[string]*rpctimeout.RPCTimeout{})
+			observer.OnApplied(key, rpcTimeoutConfigName, event.ModifyIndex, 0)
+			return
+		}
+		data := event.Value
 		configs := map[string]*rpctimeout.RPCTimeout{}
 		err := parser.Decode(configType, data, &configs)
+		result.Record(err)
 		if err != nil {
 			klog.Warnf("[consul] %s client consul rpc timeout: unmarshal data %s failed: %s, skip...", key, data, err)
+			observer.OnDecodeError(key, rpcTimeoutConfigName, err)
 			return
 		}
 
 		rpcTimeoutContainer.NotifyPolicyChange(configs)
+		observer.OnApplied(key, rpcTimeoutConfigName, event.ModifyIndex, len(configs))
 	}
 
-	consulClient.RegisterConfigCallback(key, uniqueID, onChangeCallback)
-
-	return rpcTimeoutContainer
+	err := consulClient.RegisterConfigEventCallback(key, uniqueID, onChangeCallback)
+	return rpcTimeoutContainer, utils.InitError(key, result, err, opts)
 }
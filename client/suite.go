@@ -15,10 +15,13 @@
 package client
 
 import (
+	"time"
+
 	"github.com/kitex-contrib/config-consul/consul"
 	"github.com/kitex-contrib/config-consul/utils"
 
 	"github.com/cloudwego/kitex/client"
+	kitexutils "github.com/cloudwego/kitex/pkg/utils"
 )
 
 const (
@@ -34,6 +37,8 @@ type ConsulClientSuite struct {
 	service      string
 	client       string
 	opts         utils.Options
+	// options is built eagerly by NewSuiteE.
+	options []client.Option
 }
 
 // NewSuite service is the destination service name and client is the local identity.
@@ -54,12 +59,116 @@ func NewSuite(service, client string, cli consul.Client,
 	return su
 }
 
-// Options return a list client.Option
+// NewSuiteE is the error-returning version of NewSuite, the options are built eagerly so
+// the template rendering, consul connectivity and initial decode errors are returned here.
+func NewSuiteE(service, client string, cli consul.Client,
+	opts ...utils.Option,
+) (*ConsulClientSuite, error) {
+	su := NewSuite(service, client, cli, opts...)
+	options, err := su.OptionsE()
+	if err != nil {
+		return nil, err
+	}
+	su.options = options
+	return su, nil
+}
+
+// Options return a list client.Option. The dimensions of the keys that aren't set by the options
+// are filled from the basic info of the client, e.g. set by client.WithClientBasicInfo before the
+// suite, and then from the one of the destination service.
 func (s *ConsulClientSuite) Options() []client.Option {
+	if s.options != nil {
+		return s.options
+	}
+	return []client.Option{{F: func(o *client.Options, di *kitexutils.Slice) {
+		s.opts = s.opts.FillEndpointBasicInfo(o.Cli, o.Svr)
+		for _, opt := range s.build() {
+			opt.F(o, di)
+		}
+	}}}
+}
+
+// build builds the options of all the categories.
+func (s *ConsulClientSuite) build() []client.Option {
+	// all the categories share one deadline when waiting for the initial config.
+	deadline := time.Now().Add(s.opts.InitialConfigTimeout)
 	opts := make([]client.Option, 0, 7)
-	opts = append(opts, WithCircuitBreaker(s.service, s.client, s.consulClient, s.uid, s.opts)...)
-	opts = append(opts, WithRetryPolicy(s.service, s.client, s.consulClient, s.uid, s.opts)...)
-	opts = append(opts, WithRPCTimeout(s.service, s.client, s.consulClient, s.uid, s.opts)...)
-	opts = append(opts, WithDegradation(s.service, s.client, s.consulClient, s.uid, s.opts)...)
+	opts = append(opts, WithCircuitBreaker(s.service, s.client, s.consulClient, s.uid, s.opts.Remaining(deadline))...)
+	opts = append(opts, WithRetryPolicy(s.service, s.client, s.consulClient, s.uid, s.opts.Remaining(deadline))...)
+	opts = append(opts, WithRPCTimeout(s.service, s.client, s.consulClient, s.uid, s.opts.Remaining(deadline))...)
+	opts = append(opts, WithDegradation(s.service, s.client, s.consulClient, s.uid, s.opts.Remaining(deadline))...)
 	return opts
 }
+
+// categoryBuilder builds the options of a category, and returns the function closing the
+// resources of the options besides the listener, it's nil if there is none.
+type categoryBuilder func(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, func() error, error)
+
+// withoutClose adapts the builder of a category holding no resources besides the listener.
+func withoutClose(builder func(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, error)) categoryBuilder {
+	return func(dest, src string, consulClient consul.Client, uniqueID int64, opts utils.Options) ([]client.Option, func() error, error) {
+		options, err := builder(dest, src, consulClient, uniqueID, opts)
+		return options, nil, err
+	}
+}
+
+// OptionsE is the error-returning version of Options. If any category fails, the listeners
+// of all the categories are deregistered, the circuit breaker suite and the retry container
+// already built are closed, and the error is returned.
+func (s *ConsulClientSuite) OptionsE() ([]client.Option, error) {
+	if s.options != nil {
+		return s.options, nil
+	}
+	builders := []categoryBuilder{
+		circuitBreakerE,
+		retryPolicyE,
+		withoutClose(WithRPCTimeoutE),
+		withoutClose(WithDegradationE),
+	}
+	deadline := time.Now().Add(s.opts.InitialConfigTimeout)
+	opts := make([]client.Option, 0, 7)
+	var closers []func() error
+	for _, builder := range builders {
+		o, closer, err := builder(s.service, s.client, s.consulClient, s.uid, s.opts.Remaining(deadline))
+		if err != nil {
+			s.deregister()
+			for _, closer := range closers {
+				closer()
+			}
+			return nil, err
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		opts = append(opts, o...)
+	}
+	return opts, nil
+}
+
+// deregister cancels the configuration listeners of all the categories.
+func (s *ConsulClientSuite) deregister() {
+	for _, category := range []string{circuitBreakerConfigName, retryConfigName, rpcTimeoutConfigName, degradationConfigName} {
+		param, err := configParam(category, s.service, s.client, s.consulClient, s.opts)
+		if err != nil {
+			continue
+		}
+		s.consulClient.DeregisterConfig(param.ID(), s.uid)
+	}
+}
+
+// configParam renders the consul key of the category between the client and the destination service.
+func configParam(category, dest, src string, consulClient consul.Client, opts utils.Options) (consul.Key, error) {
+	cpc := opts.ConfigParamConfig(category, dest, src)
+	param, err := consulClient.ClientConfigParam(cpc)
+	if err != nil {
+		return param, err
+	}
+	if param.Fallbacks, err = opts.FallbackKeys(param.Prefix, cpc); err != nil {
+		return param, err
+	}
+	for _, f := range opts.ConsulCustomFunctions {
+		f(&param)
+	}
+	consulClient.DeclareKey(param)
+	return param, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+)
+
+func TestSuiteE(t *testing.T) {
+	cli, err := consultest.NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+	keys := []string{
+		"KitexConfig/ClientName/ServiceName/circuit_break",
+		retryKey,
+		"KitexConfig/ClientName/ServiceName/rpc_timeout",
+		"KitexConfig/ClientName/ServiceName/degradation",
+	}
+
+	// the retry fails after the circuit breaker is built, all the listeners are deregistered.
+	cli.Set(retryKey, "invalid")
+	_, err = NewSuiteE("ServiceName", "ClientName", cli)
+	test.Assert(t, err != nil)
+	for _, key := range keys {
+		test.Assert(t, cli.Registered(key) == 0, key)
+	}
+
+	cli.Set(retryKey, "{}")
+	suite, err := NewSuiteE("ServiceName", "ClientName", cli)
+	test.Assert(t, err == nil, err)
+	opts := suite.Options()
+	test.Assert(t, len(opts) == 9, len(opts))
+	// the options built by NewSuiteE are reused instead of registering the listeners again.
+	again := suite.Options()
+	test.Assert(t, len(again) == len(opts) && &again[0] == &opts[0])
+	again, err = suite.OptionsE()
+	test.Assert(t, err == nil && &again[0] == &opts[0], err)
+	for _, key := range keys {
+		test.Assert(t, cli.Registered(key) == 1, key)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is the local copy of a config value, it's saved to the cache directory
+// after the value is decoded successfully.
+type Snapshot struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModifyIndex uint64 `json:"modify_index"`
+	// Flags are the KV flags of the value, they select the config type of the Auto keys.
+	Flags   uint64    `json:"flags,omitempty"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// snapshotCache persists the snapshots to the cache directory, one file per key.
+type snapshotCache struct {
+	dir string
+
+	mu sync.Mutex
+	// served records the index of the snapshots used when consul is unreachable.
+	served map[string]uint64
+}
+
+func newSnapshotCache(dir string) *snapshotCache {
+	return &snapshotCache{
+		dir:    dir,
+		served: make(map[string]uint64),
+	}
+}
+
+func (s *snapshotCache) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+// save writes the snapshot of the key, the file is replaced atomically.
+func (s *snapshotCache) save(key, value string, index, flags uint64) error {
+	data, err := json.Marshal(&Snapshot{
+		Key:         key,
+		Value:       value,
+		ModifyIndex: index,
+		Flags:       flags,
+		SavedAt:     time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(key))
+}
+
+// load reads the snapshot of the key.
+func (s *snapshotCache) load(key string) (*Snapshot, error) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// remove deletes the snapshot of the key.
+func (s *snapshotCache) remove(key string) error {
+	return os.Remove(s.path(key))
+}
+
+// markServed records that the snapshot is used in place of the consul value.
+func (s *snapshotCache) markServed(key string, index uint64) {
+	s.mu.Lock()
+	s.served[key] = index
+	s.mu.Unlock()
+}
+
+// recovered clears the served record of the key and returns the index of the served snapshot.
+func (s *snapshotCache) recovered(key string) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, ok := s.served[key]
+	delete(s.served, key)
+	return index, ok
+}
+
+// decodeTracker records whether any callback decodes the value successfully.
+type decodeTracker struct {
+	ConfigParser
+	decoded atomic.Bool
+}
+
+func (t *decodeTracker) Decode(configType ConfigType, data string, config interface{}) error {
+	err := t.ConfigParser.Decode(configType, data, config)
+	if err == nil {
+		t.decoded.Store(true)
+	}
+	return err
+}
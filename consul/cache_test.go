@@ -0,0 +1,53 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestSnapshotCache(t *testing.T) {
+	cache := newSnapshotCache(t.TempDir())
+	key := "KitexConfig/ClientName/ServiceName/retry"
+
+	_, err := cache.load(key)
+	test.Assert(t, os.IsNotExist(err))
+
+	test.Assert(t, cache.save(key, `{"*":{"enable":true}}`, 10, 0) == nil)
+	test.Assert(t, cache.save(key, `{"*":{"enable":false}}`, 12, FlagsYAML) == nil)
+	snapshot, err := cache.load(key)
+	test.Assert(t, err == nil)
+	test.Assert(t, snapshot.Key == key)
+	test.Assert(t, snapshot.Value == `{"*":{"enable":false}}`)
+	test.Assert(t, snapshot.ModifyIndex == 12 && snapshot.Flags == FlagsYAML)
+
+	cache.markServed(key, snapshot.ModifyIndex)
+	index, ok := cache.recovered(key)
+	test.Assert(t, ok && index == 12)
+	_, ok = cache.recovered(key)
+	test.Assert(t, !ok)
+}
+
+func TestDecodeTracker(t *testing.T) {
+	tracker := &decodeTracker{ConfigParser: defaultConfigParse()}
+	var config map[string]interface{}
+	test.Assert(t, tracker.Decode(JSON, "{", &config) != nil)
+	test.Assert(t, !tracker.decoded.Load())
+	test.Assert(t, tracker.Decode(JSON, "{}", &config) == nil)
+	test.Assert(t, tracker.decoded.Load())
+}
@@ -0,0 +1,270 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	yaml "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+// mergedTypes are the config types the layers of a key chain can be merged in, the merged
+// value is encoded in the mapped type. HCL is merged as JSON as it's decoded by the json tags,
+// and the layers of the Auto type are decoded in their own types and merged as JSON.
+var mergedTypes = map[ConfigType]ConfigType{
+	JSON: JSON,
+	YAML: YAML,
+	HCL:  JSON,
+	Auto: JSON,
+}
+
+// mergedParser decodes the merged value of a key chain in the type it's encoded in.
+type mergedParser struct {
+	ConfigParser
+	configType ConfigType
+}
+
+func (p mergedParser) Decode(_ ConfigType, data string, config interface{}) error {
+	return p.ConfigParser.Decode(p.configType, data, config)
+}
+
+// keyChain merges the values of the layers of a key, from the lowest priority, and delivers
+// the merged value to the callback as the value of the key.
+type keyChain struct {
+	key        string
+	layers     []string
+	configType ConfigType
+	callback   func(ConfigEvent, ConfigParser)
+	// id, category and observer report the invalid layers as the decode errors of the key.
+	id       string
+	category string
+	observer Observer
+
+	mu     sync.Mutex
+	ready  bool
+	parser ConfigParser
+	events []ConfigEvent
+	loaded []bool
+	// received records the layers whose value or absence is received.
+	received []bool
+	// value and index are the last value delivered, delivered is false if nothing or the deletion is delivered.
+	value     string
+	index     uint64
+	delivered bool
+	// notified is true once a value or the deletion is delivered.
+	notified bool
+}
+
+// registerChain registers the callback on every layer of the declared key, the merged value is
+// delivered once all the layers are registered.
+func (c *client) registerChain(declared Key, uniqueID int64, callback func(ConfigEvent, ConfigParser)) error {
+	key := declared.ID()
+	if _, ok := mergedTypes[declared.Type]; !ok {
+		return fmt.Errorf("key chain of %s doesn't support config type %s", key, declared.Type)
+	}
+	layers := make([]string, 0, len(declared.Fallbacks)+1)
+	for _, fallback := range declared.Fallbacks {
+		layers = append(layers, fallbackKey(declared, fallback).ID())
+	}
+	layers = append(layers, key)
+	// the declaration may be replaced before the callback is deregistered, the layers
+	// registered now are the ones deregistered.
+	c.m.Lock()
+	c.chains[chainID{key: key, uniqueID: uniqueID}] = declared
+	c.m.Unlock()
+	chain := &keyChain{
+		// the merged events carry the consul key like the ones of the single keys.
+		key:        keyName(declared),
+		id:         key,
+		category:   declared.Category,
+		observer:   c.observer,
+		layers:     layers,
+		configType: declared.Type,
+		callback:   callback,
+		parser:     c.parser,
+		events:     make([]ConfigEvent, len(layers)),
+		loaded:     make([]bool, len(layers)),
+		received:   make([]bool, len(layers)),
+	}
+	var errs []error
+	for i, layer := range layers {
+		i := i
+		err := c.register(layer, uniqueID, func(event ConfigEvent, parser ConfigParser) {
+			chain.update(i, event, parser)
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	chain.start()
+	return errors.Join(errs...)
+}
+
+// chainID identifies the key chain registered by a callback.
+type chainID struct {
+	key      string
+	uniqueID int64
+}
+
+// deregisterChain deregisters the callbacks of the layers of the key chain registered by the
+// uniqueID, it returns false if the uniqueID hasn't registered a key chain on the key.
+func (c *client) deregisterChain(key string, uniqueID int64) bool {
+	id := chainID{key: key, uniqueID: uniqueID}
+	c.m.Lock()
+	declared, ok := c.chains[id]
+	delete(c.chains, id)
+	c.m.Unlock()
+	if !ok {
+		return false
+	}
+	for _, fallback := range declared.Fallbacks {
+		c.deregister(fallbackKey(declared, fallback).ID(), uniqueID)
+	}
+	c.deregister(declared.ID(), uniqueID)
+	return true
+}
+
+// chainOf returns the declaration of the key if it has fallbacks.
+func (c *client) chainOf(key string) (Key, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	declared, ok := c.declared[key]
+	return declared, ok && len(declared.Fallbacks) > 0
+}
+
+func (ch *keyChain) update(layer int, event ConfigEvent, parser ConfigParser) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.events[layer], ch.loaded[layer], ch.received[layer] = event, !event.Deleted, true
+	ch.parser = parser
+	if ch.ready {
+		ch.dispatch()
+	}
+}
+
+// start delivers the value merged from the initial values of the layers.
+func (ch *keyChain) start() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.ready = true
+	ch.dispatch()
+}
+
+// dispatch merges the layers and delivers the value if it's changed, it must be called with mu held.
+func (ch *keyChain) dispatch() {
+	var index, flags uint64
+	var dc string
+	var merged map[string]interface{}
+	for i, event := range ch.events {
+		if event.ModifyIndex > index {
+			index = event.ModifyIndex
+		}
+		if !ch.loaded[i] {
+			continue
+		}
+		layer, err := decodeLayer(EventParser(ch.parser, event), ch.configType, event.Value)
+		if err != nil {
+			// the last merged value is kept until the layer is fixed.
+			klog.Warnf("[consul] key: %s layer %s of the key chain is invalid, keep the last value: %s", ch.key, event.Key, err)
+			ch.observer.OnDecodeError(ch.id, ch.category, fmt.Errorf("layer %s: %w", event.Key, err))
+			return
+		}
+		merged = mergeValues(merged, layer)
+		flags, dc = event.Flags, event.Datacenter
+	}
+	if merged == nil {
+		// the chain whose layers are all missing initially is reported as deleted, like a missing key.
+		if ch.delivered || !ch.notified && ch.allReceived() {
+			ch.delivered, ch.notified = false, true
+			ch.callback(ConfigEvent{Key: ch.key, PrevValue: ch.value, ModifyIndex: index, Deleted: true}, ch.parser)
+			ch.value, ch.index = "", index
+		}
+		return
+	}
+	value, err := encodeMerged(mergedTypes[ch.configType], merged)
+	if err != nil {
+		klog.Warnf("[consul] key: %s encode the merged value failed: %s", ch.key, err)
+		return
+	}
+	if ch.delivered && value == ch.value {
+		return
+	}
+	event := ConfigEvent{
+		Key:         ch.key,
+		Value:       value,
+		PrevValue:   ch.value,
+		ModifyIndex: index,
+		Flags:       flags,
+		Datacenter:  dc,
+	}
+	ch.value, ch.index, ch.delivered, ch.notified = value, index, true, true
+	ch.callback(event, mergedParser{ConfigParser: ch.parser, configType: mergedTypes[ch.configType]})
+}
+
+// allReceived returns true if the value or absence of every layer is received, it must be called with mu held.
+func (ch *keyChain) allReceived() bool {
+	for _, received := range ch.received {
+		if !received {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeLayer decodes the value of a layer to a generic object, the empty value is an empty object.
+func decodeLayer(parser ConfigParser, configType ConfigType, value string) (map[string]interface{}, error) {
+	layer := map[string]interface{}{}
+	if strings.TrimSpace(value) == "" {
+		return layer, nil
+	}
+	if err := parser.Decode(configType, value, &layer); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// mergeValues deep-merges the upper object into the lower one, the objects are merged by
+// their fields recursively and the other values of the upper one replace the lower ones.
+func mergeValues(lower, upper map[string]interface{}) map[string]interface{} {
+	if lower == nil {
+		lower = make(map[string]interface{}, len(upper))
+	}
+	for k, uv := range upper {
+		um, uok := uv.(map[string]interface{})
+		lm, lok := lower[k].(map[string]interface{})
+		if uok && lok {
+			lower[k] = mergeValues(lm, um)
+			continue
+		}
+		lower[k] = uv
+	}
+	return lower
+}
+
+func encodeMerged(configType ConfigType, merged map[string]interface{}) (string, error) {
+	var data []byte
+	var err error
+	if configType == YAML {
+		data, err = yaml.Marshal(merged)
+	} else {
+		data, err = json.Marshal(merged)
+	}
+	return string(data), err
+}
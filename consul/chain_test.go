@@ -0,0 +1,85 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestKeyChainMerge(t *testing.T) {
+	var events []ConfigEvent
+	chain := &keyChain{
+		key:        "top",
+		configType: YAML,
+		callback: func(event ConfigEvent, parser ConfigParser) {
+			events = append(events, event)
+		},
+		parser:   defaultConfigParse(),
+		events:   make([]ConfigEvent, 2),
+		loaded:   make([]bool, 2),
+		received: make([]bool, 2),
+	}
+	chain.update(0, ConfigEvent{Key: "lower", Value: "'*':\n  timeout: 100\n  retries: 1\n", ModifyIndex: 3}, chain.parser)
+	test.Assert(t, len(events) == 0)
+	chain.update(1, ConfigEvent{Key: "top", Value: "'*':\n  retries: 2\nEcho:\n  timeout: 50\n", ModifyIndex: 2}, chain.parser)
+	chain.start()
+	test.Assert(t, len(events) == 1)
+	test.Assert(t, events[0].ModifyIndex == 3)
+
+	var merged map[string]map[string]int
+	err := chain.parser.Decode(YAML, events[0].Value, &merged)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, merged["*"]["timeout"] == 100 && merged["*"]["retries"] == 2 && merged["Echo"]["timeout"] == 50, merged)
+
+	// the unchanged merged value isn't delivered again.
+	chain.update(1, ConfigEvent{Key: "top", Value: "Echo:\n  timeout: 50\n'*':\n  retries: 2\n", ModifyIndex: 4}, chain.parser)
+	test.Assert(t, len(events) == 1)
+
+	chain.update(0, ConfigEvent{Key: "lower", ModifyIndex: 5, Deleted: true}, chain.parser)
+	chain.update(1, ConfigEvent{Key: "top", ModifyIndex: 6, Deleted: true}, chain.parser)
+	test.Assert(t, len(events) == 3)
+	test.Assert(t, events[2].Deleted && events[2].ModifyIndex == 6)
+}
+
+func TestKeyChainMissing(t *testing.T) {
+	var events []ConfigEvent
+	chain := &keyChain{
+		key:        "top",
+		configType: JSON,
+		callback: func(event ConfigEvent, parser ConfigParser) {
+			events = append(events, event)
+		},
+		parser:   defaultConfigParse(),
+		events:   make([]ConfigEvent, 2),
+		loaded:   make([]bool, 2),
+		received: make([]bool, 2),
+	}
+	chain.update(1, ConfigEvent{Key: "top", ModifyIndex: 2, Deleted: true}, chain.parser)
+	// nothing is delivered until every layer is read.
+	chain.start()
+	test.Assert(t, len(events) == 0)
+
+	// the chain whose layers are all missing is reported as deleted once.
+	chain.update(0, ConfigEvent{Key: "lower", ModifyIndex: 2, Deleted: true}, chain.parser)
+	test.Assert(t, len(events) == 1)
+	test.Assert(t, events[0].Key == "top" && events[0].Deleted, events[0])
+	chain.update(0, ConfigEvent{Key: "lower", ModifyIndex: 3, Deleted: true}, chain.parser)
+	test.Assert(t, len(events) == 1)
+
+	chain.update(0, ConfigEvent{Key: "lower", Value: `{"a":1}`, ModifyIndex: 4}, chain.parser)
+	test.Assert(t, len(events) == 2 && events[1].Value == `{"a":1}`, events)
+}
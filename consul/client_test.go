@@ -0,0 +1,882 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+)
+
+const testKey = "KitexConfig/ClientName/ServiceName/retry"
+
+func TestPrefix(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr()})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+
+	const prefix = "KitexConfig/ServiceName/retry"
+	srv.Set(prefix+"/Echo", "1")
+	srv.Set(prefix+"X/Other", "2")
+	subtrees := make(chan map[string]string, 10)
+	err = cli.RegisterPrefixCallback(prefix, 1, func(values map[string]string, _ consul.PrefixDiff, _ consul.ConfigParser) {
+		subtrees <- values
+	})
+	test.Assert(t, err == nil)
+	// the initial subtree is delivered once, either by the registration or by the watch.
+	test.Assert(t, reflect.DeepEqual(receiveSubtree(t, subtrees), map[string]string{"Echo": "1"}))
+	srv.Set(prefix+"X/Other", "3")
+	srv.Set(prefix+"/Ping", "4")
+	test.Assert(t, reflect.DeepEqual(receiveSubtree(t, subtrees), map[string]string{"Echo": "1", "Ping": "4"}))
+	select {
+	case values := <-subtrees:
+		t.Fatalf("unexpected subtree %v", values)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func receiveSubtree(t *testing.T, subtrees chan map[string]string) map[string]string {
+	t.Helper()
+	select {
+	case values := <-subtrees:
+		return values
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the subtree")
+	}
+	return nil
+}
+
+func TestSnapshotRecovery(t *testing.T) {
+	dir := t.TempDir()
+	srv := consultest.NewServer()
+	// the index of the snapshot is newer than the one of the next server.
+	for i := 0; i < 50; i++ {
+		srv.Set(testKey, `{"source":"snapshot"}`)
+	}
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), CacheDir: dir})
+	test.Assert(t, err == nil)
+	err = cli.RegisterConfigCallbackE(testKey, 1, func(value string, parser consul.ConfigParser) {
+		var config map[string]string
+		parser.Decode(consul.JSON, value, &config)
+	})
+	test.Assert(t, err == nil)
+	cli.Close(context.Background())
+	srv.Close()
+
+	srv = consultest.NewServer()
+	defer srv.Close()
+	srv.SetAvailable(false)
+	cli, err = consul.NewClient(consul.Options{Addr: srv.Addr(), CacheDir: dir, RetryBackoff: 10 * time.Millisecond})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil)
+	event := receive(t, events)
+	test.Assert(t, event.Value == `{"source":"snapshot"}` && event.FromSnapshot && event.ModifyIndex > 50, event)
+
+	// the live value replaces the snapshot once consul is back, though its index is older.
+	srv.Set(testKey, `{"source":"consul"}`)
+	srv.SetAvailable(true)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"source":"consul"}` && !event.FromSnapshot, event)
+	test.Assert(t, event.PrevValue == `{"source":"snapshot"}`)
+}
+
+func TestTLS(t *testing.T) {
+	srv := consultest.NewTLSServer()
+	defer srv.Close()
+	srv.SetBasicAuth("user", "password")
+	srv.Set(testKey, `{"a":1}`)
+
+	// the CA of the server isn't trusted.
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), TLS: &consul.TLSConfig{}})
+	test.Assert(t, err == nil)
+	err = cli.RegisterConfigCallbackE(testKey, 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, err != nil)
+	cli.Close(context.Background())
+
+	cli, err = consul.NewClient(consul.Options{
+		Addr:      srv.Addr(),
+		TLS:       &consul.TLSConfig{CAPem: srv.CAPem()},
+		BasicAuth: &consul.BasicAuth{Username: "user", Password: "password"},
+	})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receive(t, events).Value == `{"a":1}`)
+
+	// the updates are received by the watch, which shares the TLS and auth settings.
+	srv.Set(testKey, `{"a":2}`)
+	test.Assert(t, receive(t, events).Value == `{"a":2}`)
+}
+
+func TestToken(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.SetToken("t1")
+	srv.Set(testKey, "v1")
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), Token: "expired"})
+	test.Assert(t, err == nil)
+	err = cli.RegisterConfigCallbackE(testKey, 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, errors.Is(err, consul.ErrPermissionDenied), err)
+	cli.Close(context.Background())
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	test.Assert(t, os.WriteFile(tokenFile, []byte("t1\n"), 0o600) == nil)
+	cli, err = consul.NewClient(consul.Options{Addr: srv.Addr(), TokenFile: tokenFile})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receive(t, events).Value == "v1")
+
+	// the watch is restarted with the new token.
+	srv.SetToken("t2")
+	cli.SetToken("t2")
+	srv.Set(testKey, "v2")
+	event := receive(t, events)
+	test.Assert(t, event.Value == "v2", event)
+	srv.Set(testKey, "v3")
+	test.Assert(t, receive(t, events).Value == "v3")
+}
+
+func TestFailover(t *testing.T) {
+	srv1 := consultest.NewServer()
+	defer srv1.Close()
+	srv2 := srv1.NewAgent()
+	defer srv2.Close()
+	srv1.Set(testKey, "v1")
+
+	cli, err := consul.NewClient(consul.Options{Addrs: []string{srv1.Addr(), srv2.Addr()}})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receive(t, events).Value == "v1")
+
+	// the watch fails over to the second agent, and keeps the index of the first one.
+	srv1.SetAvailable(false)
+	srv2.Set(testKey, "v2")
+	event := receive(t, events)
+	test.Assert(t, event.Value == "v2" && event.PrevValue == "v1", event.Value)
+	srv2.Set(testKey, "v3")
+	test.Assert(t, receive(t, events).Value == "v3")
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/limit", 2, func(string, consul.ConfigParser) {})
+	test.Assert(t, err == nil, err)
+}
+
+func TestHealth(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.Set(testKey, "v1")
+
+	observer := newFetchObserver()
+	cli, err := consul.NewClient(consul.Options{
+		Addr:            srv.Addr(),
+		WaitTime:        100 * time.Millisecond,
+		RetryBackoff:    10 * time.Millisecond,
+		MaxRetryBackoff: 20 * time.Millisecond,
+		Observers:       []consul.Observer{observer},
+	})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	_, ok := cli.Health(testKey)
+	test.Assert(t, !ok)
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receive(t, events).Value == "v1")
+	waitHealth(t, cli, observer, consul.WatchHealthy)
+
+	// the watch keeps retrying until consul is back.
+	srv.SetAvailable(false)
+	health := waitHealth(t, cli, observer, consul.WatchFailed)
+	test.Assert(t, health.LastError != nil && health.Failures >= 5, health)
+	srv.SetAvailable(true)
+	srv.Set(testKey, "v2")
+	test.Assert(t, receive(t, events).Value == "v2")
+	health = waitHealth(t, cli, observer, consul.WatchHealthy)
+	test.Assert(t, health.LastError == nil && !health.LastSuccess.IsZero(), health)
+}
+
+func TestMissingKey(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	cli, err := consul.NewClient(consul.Options{
+		Addr: srv.Addr(),
+		KeyTemplates: map[string]map[consul.ConfigType]string{
+			"retry": {consul.YAML: "'*':\n  enable: false\n"},
+		},
+	})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	key, err := cli.ClientConfigParam(&consul.ConfigParamConfig{
+		Category:          "retry",
+		ClientServiceName: "ClientName",
+		ServerServiceName: "ServiceName",
+	}, func(k *consul.Key) { k.Type = consul.YAML })
+	test.Assert(t, err == nil)
+	cli.DeclareKey(key)
+	name := key.Prefix + "/" + key.Path
+	err = cli.RegisterConfigCallbackE(name, 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, err == nil)
+	value := waitKey(t, srv, name)
+	test.Assert(t, value == "'*':\n  enable: false\n", value)
+
+	// the undeclared keys are created as JSON.
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/limit", 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, err == nil)
+	test.Assert(t, waitKey(t, srv, "KitexConfig/ServiceName/limit") == "{}")
+
+	for _, opts := range []consul.Options{
+		{Addr: srv.Addr(), MissingKey: consul.MissingKeyIgnore},
+		{Addr: srv.Addr(), ReadOnly: true},
+	} {
+		observer := newFetchObserver()
+		opts.Observers = []consul.Observer{observer}
+		cli, err := consul.NewClient(opts)
+		test.Assert(t, err == nil)
+		events := make(chan consul.ConfigEvent, 10)
+		err = cli.RegisterConfigEventCallback("missing", 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+			events <- event
+		})
+		test.Assert(t, err == nil)
+		// the callbacks know the key is missing.
+		test.Assert(t, receive(t, events).Deleted)
+		waitHealthOf(t, cli, observer, "missing", consul.WatchHealthy)
+		_, ok := srv.Get("missing")
+		test.Assert(t, !ok)
+		// the callbacks receive the value once it's created by others.
+		srv.Set("missing", "{}")
+		test.Assert(t, receive(t, events).Value == "{}")
+		srv.Delete("missing")
+		test.Assert(t, receive(t, events).Deleted)
+		cli.Close(context.Background())
+	}
+
+	_, err = consul.NewClient(consul.Options{Addr: srv.Addr(), MissingKey: "skip"})
+	test.Assert(t, err != nil)
+}
+
+func TestEmptyKey(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.Set("empty", "")
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr()})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback("empty", 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil)
+	// the key with an empty value is loaded like a missing one, and it isn't overwritten.
+	test.Assert(t, receive(t, events).Deleted)
+	value, ok := srv.Get("empty")
+	test.Assert(t, ok && value == "", value)
+
+	srv.Set("empty", "{}")
+	test.Assert(t, receive(t, events).Value == "{}")
+	srv.Set("empty", "")
+	test.Assert(t, receive(t, events).Deleted)
+}
+
+func TestKeyLocation(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.SetToken("token")
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr()})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	central := consultest.Location{Namespace: "governance", Partition: "central"}
+	key, err := cli.ClientConfigParam(&consul.ConfigParamConfig{
+		Category:          "retry",
+		ClientServiceName: "ClientName",
+		ServerServiceName: "ServiceName",
+	}, func(k *consul.Key) {
+		k.Namespace, k.Partition, k.Token = central.Namespace, central.Partition, "token"
+	})
+	test.Assert(t, err == nil)
+	cli.DeclareKey(key)
+	name := key.Prefix + "/" + key.Path
+	srv.Set(name, `{"location":"default"}`)
+	srv.SetIn(central, name, `{"location":"central"}`)
+
+	events := make(chan consul.ConfigEvent, 10)
+	// the key in a location is registered by its ID.
+	err = cli.RegisterConfigEventCallback(key.ID(), 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	event := receive(t, events)
+	test.Assert(t, event.Key == name && event.Value == `{"location":"central"}`, event)
+	srv.SetIn(central, name, `{"location":"central","updated":true}`)
+	test.Assert(t, receive(t, events).Value == `{"location":"central","updated":true}`)
+
+	// the missing key is created in its location.
+	limit := consul.Key{Type: consul.JSON, Prefix: "KitexConfig", Path: "ServiceName/limit", Datacenter: "dc2", Token: "token"}
+	cli.DeclareKey(limit)
+	err = cli.RegisterConfigCallbackE(limit.ID(), 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, waitKeyIn(t, srv, consultest.Location{Datacenter: "dc2"}, "KitexConfig/ServiceName/limit") == "{}")
+	_, ok := srv.Get("KitexConfig/ServiceName/limit")
+	test.Assert(t, !ok)
+
+	// the keys without a token use the one of the client.
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/degradation", 1, func(string, consul.ConfigParser) {})
+	test.Assert(t, errors.Is(err, consul.ErrPermissionDenied), err)
+	cli.DeregisterConfig("KitexConfig/ServiceName/degradation", 1)
+}
+
+func TestKeyPartitions(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	dir := t.TempDir()
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), CacheDir: dir})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	a := consul.Key{Type: consul.JSON, Prefix: "KitexConfig", Path: "ServiceName/limit", Partition: "a"}
+	b := a
+	b.Partition = "b"
+	test.Assert(t, a.ID() != b.ID() && a.ID() != "KitexConfig/ServiceName/limit", a.ID())
+	cli.DeclareKey(a)
+	cli.DeclareKey(b)
+	const name = "KitexConfig/ServiceName/limit"
+	srv.SetIn(consultest.Location{Partition: "a"}, name, `{"partition":"a"}`)
+	srv.SetIn(consultest.Location{Partition: "b"}, name, `{"partition":"b"}`)
+
+	eventsA := make(chan consul.ConfigEvent, 10)
+	eventsB := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(a.ID(), 1, func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		eventsA <- event
+		parser.Decode(consul.JSON, event.Value, &map[string]interface{}{})
+	})
+	test.Assert(t, err == nil, err)
+	err = cli.RegisterConfigEventCallback(b.ID(), 1, func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		eventsB <- event
+		parser.Decode(consul.JSON, event.Value, &map[string]interface{}{})
+	})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receive(t, eventsA).Value == `{"partition":"a"}`)
+	test.Assert(t, receive(t, eventsB).Value == `{"partition":"b"}`)
+
+	// each location has its own watch and snapshot.
+	_, ok := cli.Health(a.ID())
+	test.Assert(t, ok)
+	_, ok = cli.Health(b.ID())
+	test.Assert(t, ok)
+	srv.SetIn(consultest.Location{Partition: "b"}, name, `{"partition":"b","updated":true}`)
+	test.Assert(t, receive(t, eventsB).Value == `{"partition":"b","updated":true}`)
+	select {
+	case event := <-eventsA:
+		t.Fatalf("unexpected event of partition a: %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+	files, err := os.ReadDir(dir)
+	test.Assert(t, err == nil && len(files) == 2, files)
+
+	// the key chain in a location merges the layers of the location.
+	srv.SetIn(consultest.Location{Partition: "b"}, "KitexConfig/*/limit", `{"default":true}`)
+	chain := b
+	chain.Fallbacks = []string{"KitexConfig/*/limit"}
+	cli.DeclareKey(chain)
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(chain.ID(), 2, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	event := receive(t, events)
+	test.Assert(t, event.Key == name && event.Value == `{"default":true,"partition":"b","updated":true}`, event)
+}
+
+func TestDatacenterFallback(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	// the short wait time makes the fallback datacenter check the preferred one quickly.
+	cli, err := consul.NewClient(consul.Options{
+		Addr:                srv.Addr(),
+		FallbackDataCenters: []string{"dc2"},
+		WaitTime:            100 * time.Millisecond,
+	})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	const key = "KitexConfig/ServiceName/limit"
+	dc2 := consultest.Location{Datacenter: "dc2"}
+	srv.SetIn(dc2, key, `{"dc":"dc2"}`)
+
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(key, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	event := receive(t, events)
+	test.Assert(t, event.Value == `{"dc":"dc2"}` && event.Datacenter == "dc2", event)
+	// the key missing in the preferred datacenter isn't created, as the fallback has it.
+	_, ok := srv.Get(key)
+	test.Assert(t, !ok)
+
+	// switch back once the preferred datacenter has the key.
+	srv.Set(key, `{"dc":"dc1"}`)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"dc":"dc1"}` && event.Datacenter == "dc1", event)
+	health, _ := cli.Health(key)
+	test.Assert(t, health.Datacenter == "dc1", health)
+
+	// the value of the fallback is older, it's delivered anyway as it's from another datacenter.
+	srv.SetDatacenterAvailable("dc1", false)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"dc":"dc2"}` && event.Datacenter == "dc2", event)
+	health, _ = cli.Health(key)
+	test.Assert(t, health.Datacenter == "dc2", health)
+	srv.SetDatacenterAvailable("dc1", true)
+	test.Assert(t, receive(t, events).Datacenter == "dc1")
+
+	srv.Delete(key)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"dc":"dc2"}` && !event.Deleted, event)
+	srv.DeleteIn(dc2, key)
+	event = receive(t, events)
+	test.Assert(t, event.Deleted && event.Datacenter == "dc1", event)
+}
+
+func TestAutoConfigType(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), ConfigType: consul.Auto, CacheDir: dir})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	key, err := cli.ServerConfigParam(&consul.ConfigParamConfig{Category: "limit", ServerServiceName: "ServiceName"})
+	test.Assert(t, err == nil)
+	test.Assert(t, key.Type == consul.Auto)
+	cli.DeclareKey(key)
+	name := key.Prefix + "/" + key.Path
+
+	limits := make(chan map[string]int, 10)
+	callback := func(value string, parser consul.ConfigParser) {
+		limit := map[string]int{}
+		if err := parser.Decode(key.Type, value, &limit); err != nil {
+			limit["error"] = 1
+		}
+		limits <- limit
+	}
+	err = cli.RegisterConfigCallbackE(name, 1, callback)
+	test.Assert(t, err == nil)
+	// the missing key without a suffix is created as an empty YAML document, which is loaded
+	// like a missing key.
+	test.Assert(t, waitKey(t, srv, name) == "")
+
+	srv.Set(name, `{"qps_limit":100}`)
+	test.Assert(t, receiveLimit(t, limits)["qps_limit"] == 100)
+	srv.Set(name, "qps_limit = 200")
+	test.Assert(t, receiveLimit(t, limits)["qps_limit"] == 200)
+	// the flags take precedence over the sniffing, the YAML flow mapping isn't JSON.
+	consulCli, err := api.NewClient(&api.Config{Address: srv.Addr()})
+	test.Assert(t, err == nil)
+	_, err = consulCli.KV().Put(&api.KVPair{Key: name, Value: []byte("{qps_limit: 300}"), Flags: consul.FlagsYAML}, nil)
+	test.Assert(t, err == nil)
+	test.Assert(t, receiveLimit(t, limits)["qps_limit"] == 300)
+
+	_, err = consulCli.KV().Put(&api.KVPair{Key: name, Value: []byte(`{"qps_limit":400}`), Flags: consul.FlagsYAML}, nil)
+	test.Assert(t, err == nil)
+	test.Assert(t, receiveLimit(t, limits)["qps_limit"] == 400)
+
+	// the flags are kept in the local snapshot, the value isn't sniffed as JSON.
+	srv.SetAvailable(false)
+	parser := &typeRecorder{ConfigParser: consul.DefaultConfigParser(), types: make(chan consul.ConfigType, 10)}
+	offline, err := consul.NewClient(consul.Options{Addr: srv.Addr(), ConfigType: consul.Auto, CacheDir: dir, ConfigParser: parser})
+	test.Assert(t, err == nil)
+	defer offline.Close(context.Background())
+	err = offline.RegisterConfigCallbackE(name, 1, callback)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, receiveLimit(t, limits)["qps_limit"] == 400)
+	test.Assert(t, <-parser.types == consul.YAML)
+}
+
+// typeRecorder records the config types the values are decoded in.
+type typeRecorder struct {
+	consul.ConfigParser
+	types chan consul.ConfigType
+}
+
+func (p *typeRecorder) Decode(configType consul.ConfigType, data string, config interface{}) error {
+	p.types <- configType
+	return p.ConfigParser.Decode(configType, data, config)
+}
+
+func receiveLimit(t *testing.T, limits chan map[string]int) map[string]int {
+	t.Helper()
+	select {
+	case limit := <-limits:
+		return limit
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the limit")
+	}
+	return nil
+}
+
+func waitKey(t *testing.T, srv *consultest.Server, key string) string {
+	t.Helper()
+	return waitKeyIn(t, srv, consultest.Location{}, key)
+}
+
+func waitKeyIn(t *testing.T, srv *consultest.Server, loc consultest.Location, key string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		// the channel is taken before the key is read, so the write in between isn't missed.
+		changed := srv.Changed()
+		if value, ok := srv.GetIn(loc, key); ok {
+			return value
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("timeout waiting for the key %s to be created", key)
+		}
+	}
+}
+
+func TestKeyChain(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	const global, callee = "KitexConfig/*/*/retry", "KitexConfig/*/ServiceName/retry"
+	srv.Set(global, `{"*":{"enable":true,"type":0}}`)
+	srv.Set(callee, `{"Echo":{"enable":true,"type":1}}`)
+
+	observer := &decodeErrorObserver{errs: make(chan string, 10)}
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), Observers: []consul.Observer{observer}})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	cli.DeclareKey(consul.Key{
+		Type:      consul.JSON,
+		Prefix:    "KitexConfig",
+		Path:      "ClientName/ServiceName/retry",
+		Category:  "retry",
+		Fallbacks: []string{global, callee},
+	})
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Key == testKey && !event.Deleted {
+			// the merged value is decoded by the parser passed to the callback.
+			var v map[string]map[string]interface{}
+			if err := parser.Decode(consul.JSON, event.Value, &v); err != nil {
+				event.Value = err.Error()
+			}
+		}
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	event := receive(t, events)
+	test.Assert(t, event.Key == testKey && event.Value == `{"*":{"enable":true,"type":0},"Echo":{"enable":true,"type":1}}`, event.Value)
+	// the missing top layer is created, the fallbacks aren't.
+	test.Assert(t, waitKey(t, srv, testKey) == "{}")
+
+	// the caller overrides a single field of a method.
+	srv.Set(testKey, `{"Echo":{"enable":false}}`)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"*":{"enable":true,"type":0},"Echo":{"enable":false,"type":1}}`, event.Value)
+
+	srv.Delete(callee)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"*":{"enable":true,"type":0},"Echo":{"enable":false}}`, event.Value)
+
+	// the invalid layer is reported as a decode error of the key, the last merged value is kept.
+	srv.Set(global, `{`)
+	test.Assert(t, next(t, observer.errs) == testKey+" retry", observer)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event of the invalid layer: %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	srv.Delete(global)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"Echo":{"enable":false}}`, event.Value)
+	srv.Delete(testKey)
+	event = receive(t, events)
+	test.Assert(t, event.Deleted && event.Key == testKey)
+
+	cli.DeregisterConfig(testKey, 1)
+	for _, key := range []string{testKey, global, callee} {
+		_, ok := cli.Health(key)
+		test.Assert(t, !ok, key)
+	}
+}
+
+func TestKeyChainRedeclared(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	const global, callee = "KitexConfig/*/*/retry", "KitexConfig/*/ServiceName/retry"
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr()})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	key := consul.Key{Type: consul.JSON, Prefix: "KitexConfig", Path: "ClientName/ServiceName/retry"}
+	// two suites declare the same key with different fallbacks.
+	for i, fallback := range []string{global, callee} {
+		key.Fallbacks = []string{fallback}
+		cli.DeclareKey(key)
+		err = cli.RegisterConfigEventCallback(testKey, int64(i+1), func(consul.ConfigEvent, consul.ConfigParser) {})
+		test.Assert(t, err == nil, err)
+	}
+
+	// the layers registered by the callback are deregistered with it.
+	cli.DeregisterConfig(testKey, 1)
+	_, ok := cli.Health(global)
+	test.Assert(t, !ok)
+	for _, key := range []string{testKey, callee} {
+		_, ok := cli.Health(key)
+		test.Assert(t, ok, key)
+	}
+	cli.DeregisterConfig(testKey, 2)
+	for _, key := range []string{testKey, global, callee} {
+		_, ok := cli.Health(key)
+		test.Assert(t, !ok, key)
+	}
+}
+
+func TestMissingKeyChain(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), ReadOnly: true})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	cli.DeclareKey(consul.Key{
+		Type:      consul.JSON,
+		Prefix:    "KitexConfig",
+		Path:      "ClientName/ServiceName/retry",
+		Fallbacks: []string{"KitexConfig/*/*/retry"},
+	})
+	events := make(chan consul.ConfigEvent, 10)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil, err)
+	// the chain whose layers are all missing is reported as deleted.
+	event := receive(t, events)
+	test.Assert(t, event.Key == testKey && event.Deleted, event)
+
+	srv.Set("KitexConfig/*/*/retry", `{"*":{"enable":true}}`)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"*":{"enable":true}}`, event.Value)
+}
+
+type decodeErrorObserver struct {
+	consul.BaseObserver
+	errs chan string
+}
+
+func (o *decodeErrorObserver) OnDecodeError(key, category string, _ error) {
+	o.errs <- key + " " + category
+}
+
+type recordingObserver struct {
+	consul.BaseObserver
+	events chan string
+}
+
+func (o *recordingObserver) OnUpdate(_ string, event consul.ConfigEvent) {
+	o.events <- "update " + event.Value
+}
+
+func (o *recordingObserver) OnDeleted(key string) {
+	o.events <- "deleted " + key
+}
+
+func (o *recordingObserver) OnWatchError(key string, failures int, err error) {
+	if failures == 1 {
+		o.events <- "watch error " + key
+	}
+}
+
+// healthObserver reads the health of the key when its callbacks change.
+type healthObserver struct {
+	consul.BaseObserver
+	cli     atomic.Value
+	healthy chan bool
+}
+
+func (o *healthObserver) OnCallbacks(key string, _ int) {
+	_, ok := o.cli.Load().(consul.Client).Health(key)
+	o.healthy <- ok
+}
+
+func TestObserverUsesClient(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	observer := &healthObserver{healthy: make(chan bool, 10)}
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr(), Observers: []consul.Observer{observer}})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	observer.cli.Store(cli)
+	// the observers are called without the lock of the client.
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(consul.ConfigEvent, consul.ConfigParser) {})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, <-observer.healthy)
+	cli.DeregisterConfig(testKey, 1)
+	test.Assert(t, !<-observer.healthy)
+	err = cli.RegisterPrefixCallback("KitexConfig", 1, func(map[string]string, consul.PrefixDiff, consul.ConfigParser) {})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, <-observer.healthy)
+	cli.DeregisterPrefix("KitexConfig", 1)
+	test.Assert(t, !<-observer.healthy)
+}
+
+func TestObserver(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.Set(testKey, "v1")
+
+	observer := &recordingObserver{events: make(chan string, 100)}
+	cli, err := consul.NewClient(consul.Options{
+		Addr:            srv.Addr(),
+		WaitTime:        100 * time.Millisecond,
+		RetryBackoff:    10 * time.Millisecond,
+		MaxRetryBackoff: 20 * time.Millisecond,
+		Observers:       []consul.Observer{observer},
+	})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(consul.ConfigEvent, consul.ConfigParser) {})
+	test.Assert(t, err == nil, err)
+	test.Assert(t, next(t, observer.events) == "update v1")
+
+	// the changes of the other keys aren't reported.
+	srv.Set("other", "v")
+	srv.Set(testKey, "v2")
+	test.Assert(t, next(t, observer.events) == "update v2")
+	srv.Delete(testKey)
+	test.Assert(t, next(t, observer.events) == "deleted "+testKey)
+
+	srv.SetAvailable(false)
+	test.Assert(t, next(t, observer.events) == "watch error "+testKey)
+}
+
+func next(t *testing.T, events chan string) string {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the observer event")
+	}
+	return ""
+}
+
+// fetchObserver signals the queries of the watches, the health of the watch is updated
+// before the hooks are called.
+type fetchObserver struct {
+	consul.BaseObserver
+	fetches chan struct{}
+}
+
+func newFetchObserver() *fetchObserver {
+	return &fetchObserver{fetches: make(chan struct{}, 1)}
+}
+
+func (o *fetchObserver) signal() {
+	select {
+	case o.fetches <- struct{}{}:
+	default:
+	}
+}
+
+func (o *fetchObserver) OnFetch(_ string, err error) {
+	if err == nil {
+		o.signal()
+	}
+}
+
+func (o *fetchObserver) OnWatchError(string, int, error) {
+	o.signal()
+}
+
+func waitHealth(t *testing.T, cli consul.Client, observer *fetchObserver, state consul.WatchState) consul.HealthStatus {
+	t.Helper()
+	return waitHealthOf(t, cli, observer, testKey, state)
+}
+
+func waitHealthOf(t *testing.T, cli consul.Client, observer *fetchObserver, key string, state consul.WatchState) consul.HealthStatus {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		health, ok := cli.Health(key)
+		test.Assert(t, ok)
+		// the zero health is healthy, wait for the first query as well.
+		if health.State == state && (state != consul.WatchHealthy || !health.LastSuccess.IsZero()) {
+			return health
+		}
+		select {
+		case <-observer.fetches:
+		case <-timeout:
+			t.Fatalf("timeout waiting for the health state %s, got %s", state, health.State)
+		}
+	}
+}
+
+func receive(t *testing.T, events chan consul.ConfigEvent) consul.ConfigEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the config event")
+	}
+	return consul.ConfigEvent{}
+}
@@ -17,24 +17,80 @@ package consul
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"html/template"
-	"strconv"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/hashicorp/consul/api"
-	"github.com/hashicorp/consul/api/watch"
 	"go.uber.org/zap"
 )
 
-const WatchByKey = "key"
+const (
+	WatchByKey       = "key"
+	WatchByKeyPrefix = "keyprefix"
+)
 
+// Key is the declaration of a consul key, the key is identified by its ID.
 type Key struct {
 	Type   ConfigType
 	Prefix string
 	Path   string
+	// Category is the category of the config, e.g. retry, it's set by ClientConfigParam and ServerConfigParam.
+	Category string
+	// Fallbacks are the keys of the lower layers of the key, from the lowest priority. When the key
+	// is declared with fallbacks, the callbacks registered on it receive the values of all the layers
+	// deep-merged, the upper layers override the lower ones.
+	Fallbacks []string
+	// Optional marks the keys that are never created when missing, e.g. the fallbacks.
+	Optional bool
+	// Datacenter, Namespace, Partition and Token override the ones of the client when the declared
+	// key is read, watched or created, e.g. to share the configs in a central partition. The empty
+	// ones aren't overridden, and the key with a Token isn't affected by SetToken.
+	Datacenter string
+	Namespace  string
+	Partition  string
+	Token      string
+}
+
+// ConfigEvent is a change of a watched key, it carries the metadata of the consul KVPair.
+type ConfigEvent struct {
+	Key   string
+	Value string
+	// PrevValue is the value received by the callback before this event.
+	PrevValue string
+	// ModifyIndex is the index of the latest modification of the key, or the index of
+	// the deletion if Deleted is true.
+	ModifyIndex uint64
+	CreateIndex uint64
+	Flags       uint64
+	// Datacenter is the datacenter the value is read from, the ModifyIndex is only comparable
+	// to the ones of the same datacenter.
+	Datacenter string
+	// FromSnapshot is true if the value is read from the local snapshot as consul is unreachable,
+	// the first value received from consul replaces it regardless of the ModifyIndex.
+	FromSnapshot bool
+	// Deleted is true if the key is deleted from consul, or doesn't exist when the callback is
+	// registered, Value is empty then. The key with an empty value is reported as deleted as well.
+	Deleted bool
+}
+
+// newConfigEvent converts the consul KVPair read from the datacenter to the event.
+func newConfigEvent(pair *api.KVPair, dc string) ConfigEvent {
+	return ConfigEvent{
+		Key:         pair.Key,
+		Value:       string(pair.Value),
+		ModifyIndex: pair.ModifyIndex,
+		CreateIndex: pair.CreateIndex,
+		Flags:       pair.Flags,
+		Datacenter:  dc,
+	}
 }
+
 type ListenConfig struct {
 	Key        string
 	Type       string
@@ -48,12 +104,27 @@ type Client interface {
 	SetParser(configParser ConfigParser)
 	ClientConfigParam(cpc *ConfigParamConfig, cfs ...CustomFunction) (Key, error)
 	ServerConfigParam(cpc *ConfigParamConfig, cfs ...CustomFunction) (Key, error)
+	DeclareKey(key Key)
 	RegisterConfigCallback(key string, uniqueID int64, callback func(string, ConfigParser))
+	RegisterConfigCallbackE(key string, uniqueID int64, callback func(string, ConfigParser)) error
+	RegisterConfigEventCallback(key string, uniqueID int64, callback func(ConfigEvent, ConfigParser)) error
 	DeregisterConfig(key string, uniqueID int64)
+	RegisterPrefixCallback(prefix string, uniqueID int64, callback func(map[string]string, PrefixDiff, ConfigParser)) error
+	DeregisterPrefix(prefix string, uniqueID int64)
+	SetToken(token string)
+	Observer() Observer
+	Health(key string) (HealthStatus, bool)
+	Close(ctx context.Context) error
 }
 
+// ErrClientClosed is returned when registering callbacks to a closed client.
+var ErrClientClosed = errors.New("consul client is closed")
+
 type Options struct {
-	Addr             string
+	Addr string
+	// Addrs is the list of the consul agents, it takes precedence over Addr. The requests are sent
+	// to one agent at a time and fail over to the next healthy agent when the agent is down.
+	Addrs            []string
 	Prefix           string
 	ServerPathFormat string
 	ClientPathFormat string
@@ -64,21 +135,101 @@ type Options struct {
 	Partition        string
 	LoggerConfig     *zap.Config
 	ConfigParser     ConfigParser
+	// ConfigType is the config type of the keys of the categories, JSON is used if it's empty.
+	// Auto detects the type of every value, see DetectConfigType.
+	ConfigType ConfigType
+	// CacheDir is the directory to save the snapshots of the config values, the snapshots
+	// are used when consul is unreachable. The local cache is disabled if it's empty.
+	CacheDir string
+	// Scheme is the URI scheme of the consul agent, it defaults to "https" if TLS is set, otherwise "http".
+	Scheme string
+	// TLS configures the https connection to the consul agent.
+	TLS *TLSConfig
+	// BasicAuth is the HTTP basic auth credential of the consul agent.
+	BasicAuth *BasicAuth
+	// TokenFile is the path of the file holding the ACL token, it's used instead of Token if set.
+	// The file is checked periodically and the new token is applied to the running watches.
+	TokenFile string
+	// WaitTime is the max time a blocking query of the watches waits for a change, the agent
+	// default (5 minutes) is used if it's zero.
+	WaitTime time.Duration
+	// Consistency is the consistency mode of the queries, ConsistencyDefault is used if it's empty.
+	Consistency ConsistencyMode
+	// RetryBackoff is the backoff of the first retry of a failed watch, it's doubled on every
+	// failure up to MaxRetryBackoff. A random jitter of up to the half is applied.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// FallbackDataCenters are the datacenters the keys are read from, in order, when a key is
+	// missing in DataCenter (or the datacenter of the key) or the datacenter fails. The key
+	// switches back once the preferred datacenter has it again, Health reports the datacenter
+	// serving the key.
+	FallbackDataCenters []string
+	// Observers receive the lifecycle events of the configs, see Observer.
+	Observers []Observer
+	// MissingKey decides whether a missing key is created when it's watched, MissingKeyCreate is used if it's empty.
+	MissingKey MissingKeyPolicy
+	// KeyTemplates are the values of the created keys by category and config type, e.g.
+	// KeyTemplates["retry"][YAML]. The keys without a template are created as "{}" for JSON
+	// and empty for the other types.
+	KeyTemplates map[string]map[ConfigType]string
+	// ReadOnly makes the client never write to consul, the missing keys aren't created regardless of MissingKey.
+	ReadOnly bool
 }
 
 type client struct {
 	consulCli          *api.Client
 	lconfig            *ListenConfig
 	parser             ConfigParser
+	configType         ConfigType
 	consulTimeout      time.Duration
 	prefixTemplate     *template.Template
 	serverPathTemplate *template.Template
 	clientPathTemplate *template.Template
-	cancelMap          map[string]context.CancelFunc
+	watchers           map[string]*configWatcher
+	prefixWatchers     map[string]*prefixWatcher
+	cache              *snapshotCache
 	m                  sync.Mutex
+	closed             bool
+	waitTime           time.Duration
+	consistency        ConsistencyMode
+	retryBackoff       time.Duration
+	maxRetryBackoff    time.Duration
+	// fallbackDataCenters are the datacenters read after the one of the key, see Options.FallbackDataCenters.
+	fallbackDataCenters []string
+	observer            Observers
+	// declared is the keys declared by DeclareKey, it's guarded by m.
+	declared map[string]Key
+	// chains is the declarations the key chains are registered with, it's guarded by m.
+	chains       map[chainID]Key
+	missingKey   MissingKeyPolicy
+	keyTemplates map[string]map[ConfigType]string
+	readOnly     bool
+	// token is the current ACL token, it's replaced by SetToken.
+	token atomic.Value
+	// done is closed when the client is closed.
+	done chan struct{}
+	// inflight counts the registrations and the watch handlers that are running.
+	inflight sync.WaitGroup
+}
+
+// NewClientFromEnv is like NewClient, and the options not set are loaded from the environment
+// variables (see OptionsFromEnv) before the defaults. The explicit options always take precedence,
+// and a malformed variable fails the creation.
+func NewClientFromEnv(opts Options) (Client, error) {
+	envOpts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	fillEmpty(&opts, envOpts)
+	return NewClient(opts)
 }
 
+// NewClient creates the consul client, the options not set are the defaults. The environment
+// variables aren't read, use NewClientFromEnv to load them.
 func NewClient(opts Options) (Client, error) {
+	if len(opts.Addrs) > 0 {
+		opts.Addr = opts.Addrs[0]
+	}
 	if opts.Addr == "" {
 		opts.Addr = ConsulDefaultConfigAddr
 	}
@@ -100,13 +251,46 @@ func NewClient(opts Options) (Client, error) {
 	if opts.DataCenter == "" {
 		opts.DataCenter = ConsulDefaultDataCenter
 	}
-	consulClient, err := api.NewClient(&api.Config{
-		Address:    opts.Addr,
-		Datacenter: opts.DataCenter,
-		Token:      opts.Token,
-		Namespace:  opts.NamespaceId,
-		Partition:  opts.Partition,
-	})
+	if opts.Consistency == "" {
+		opts.Consistency = ConsistencyDefault
+	}
+	if opts.MissingKey == "" {
+		opts.MissingKey = MissingKeyCreate
+	}
+	if opts.ConfigType == "" {
+		opts.ConfigType = JSON
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = ConsulDefaultRetryBackoff
+	}
+	if opts.MaxRetryBackoff < opts.RetryBackoff {
+		opts.MaxRetryBackoff = ConsulDefaultMaxRetryBackoff
+		if opts.MaxRetryBackoff < opts.RetryBackoff {
+			opts.MaxRetryBackoff = opts.RetryBackoff
+		}
+	}
+	switch opts.Consistency {
+	case ConsistencyDefault, ConsistencyStale, ConsistencyConsistent:
+	default:
+		return nil, fmt.Errorf("unsupported consistency mode %s", opts.Consistency)
+	}
+	switch opts.MissingKey {
+	case MissingKeyCreate, MissingKeyIgnore:
+	default:
+		return nil, fmt.Errorf("unsupported missing key policy %s", opts.MissingKey)
+	}
+	if opts.TokenFile != "" {
+		token, err := readTokenFile(opts.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read token file %s failed: %w", opts.TokenFile, err)
+		}
+		opts.Token = token
+	}
+	apiConf, err := apiConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	consulClient, err := api.NewClient(&apiConf)
 	if err != nil {
 		return nil, err
 	}
@@ -131,14 +315,35 @@ func NewClient(opts Options) (Client, error) {
 		Partition:  opts.Partition,
 	}
 	c := &client{
-		consulCli:          consulClient,
-		parser:             opts.ConfigParser,
-		consulTimeout:      opts.TimeOut,
-		prefixTemplate:     prefixTemplate,
-		serverPathTemplate: serverNameTemplate,
-		clientPathTemplate: clientNameTemplate,
-		lconfig:            lconfig,
-		cancelMap:          make(map[string]context.CancelFunc),
+		consulCli:           consulClient,
+		parser:              opts.ConfigParser,
+		configType:          opts.ConfigType,
+		consulTimeout:       opts.TimeOut,
+		prefixTemplate:      prefixTemplate,
+		serverPathTemplate:  serverNameTemplate,
+		clientPathTemplate:  clientNameTemplate,
+		lconfig:             lconfig,
+		watchers:            make(map[string]*configWatcher),
+		prefixWatchers:      make(map[string]*prefixWatcher),
+		done:                make(chan struct{}),
+		waitTime:            opts.WaitTime,
+		consistency:         opts.Consistency,
+		retryBackoff:        opts.RetryBackoff,
+		maxRetryBackoff:     opts.MaxRetryBackoff,
+		fallbackDataCenters: opts.FallbackDataCenters,
+		observer:            opts.Observers,
+		declared:            make(map[string]Key),
+		chains:              make(map[chainID]Key),
+		missingKey:          opts.MissingKey,
+		keyTemplates:        opts.KeyTemplates,
+		readOnly:            opts.ReadOnly,
+	}
+	c.token.Store(opts.Token)
+	if opts.TokenFile != "" {
+		go c.watchTokenFile(opts.TokenFile)
+	}
+	if opts.CacheDir != "" {
+		c.cache = newSnapshotCache(opts.CacheDir)
 	}
 	return c, nil
 }
@@ -162,7 +367,7 @@ func (c *client) ServerConfigParam(cpc *ConfigParamConfig, cfs ...CustomFunction
 //  2. ServerPath: {{.ServerServiceName}}/{{.Category}} by default.
 //     ClientPath: {{.ClientServiceName}}/{{.ServerServiceName}}/{{.Category}} by default.
 func (c *client) configParam(cpc *ConfigParamConfig, t *template.Template, cfs ...CustomFunction) (Key, error) {
-	param := Key{Type: JSON}
+	param := Key{Type: c.configType, Category: cpc.Category}
 	var err error
 	param.Path, err = c.render(cpc, t)
 	if err != nil {
@@ -179,6 +384,20 @@ func (c *client) configParam(cpc *ConfigParamConfig, t *template.Template, cfs .
 	return param, nil
 }
 
+// RenderPath renders the path template with the params in the same way as the ServerPathFormat
+// and ClientPathFormat of the client, e.g. the fallback paths of the keys.
+func RenderPath(format string, cpc *ConfigParamConfig) (string, error) {
+	t, err := template.New("path").Parse(format)
+	if err != nil {
+		return "", err
+	}
+	var tpl bytes.Buffer
+	if err = t.Execute(&tpl, cpc); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
 func (c *client) render(cpc *ConfigParamConfig, t *template.Template) (string, error) {
 	var tpl bytes.Buffer
 	err := t.Execute(&tpl, cpc)
@@ -189,91 +408,283 @@ func (c *client) render(cpc *ConfigParamConfig, t *template.Template) (string, e
 }
 
 // RegisterConfigCallback register the callback function to consul client.
+// All the callbacks registered on the same key share a single consul watch.
+// The deletion of the key is ignored, use RegisterConfigEventCallback to handle it.
+// The registration error is logged, use RegisterConfigCallbackE to handle it.
 func (c *client) RegisterConfigCallback(key string, uniqueID int64, callback func(string, ConfigParser)) {
-	go func() {
-		clientCtx, cancel := context.WithCancel(context.Background())
-		params := make(map[string]interface{})
-		params["datacenter"] = c.lconfig.DataCenter
-		params["token"] = c.lconfig.Token
-		params["type"] = c.lconfig.Type
-		params["key"] = key
-		c.lconfig.Key = key
-		kv := c.consulCli.KV()
-		get, _, _ := kv.Get(c.lconfig.Key, nil)
-		if get == nil {
-			klog.Debugf("[consul]  key:%s doesn't exist", key)
-			_, err := kv.Put(&api.KVPair{
-				Key:   c.lconfig.Key,
-				Value: []byte("{}"),
-			}, nil)
-			if err != nil {
-				klog.Errorf("[consul] Add key: %s failed,error: %s", key, err.Error())
-			}
-		}
-		c.registerCancelFunc(key, uniqueID, cancel)
-		w, err := watch.Parse(params)
-		if err != nil {
-			klog.Debugf("[consul] key:add listen for %s failed", key)
-		}
-		if w == nil {
-			klog.Debugf("[consul] key:add listen for %s failed", key)
-			return
-		}
-		klog.Debugf("[consul] key:add listen for %s successfully", key)
-		w.Handler = func(u uint64, i interface{}) {
-			if i == nil {
-				return
-			}
-			kv := i.(*api.KVPair)
-			v := string(kv.Value)
-			klog.Debugf("[consul] config key: %s updated,value is %s", key, v)
-			callback(v, c.parser)
-		}
+	if err := c.RegisterConfigCallbackE(key, uniqueID, callback); err != nil {
+		klog.Errorf("[consul] register key: %s failed: %s", key, err)
+	}
+}
 
-		go func() {
-			err := w.Run(c.lconfig.ConsulAddr)
-			if err != nil {
-				klog.Errorf("[consul] listen key: %s failed,error: %s", key, err.Error())
-			}
-		}()
-		for range clientCtx.Done() {
-			w.Stop()
+// RegisterConfigCallbackE is like RegisterConfigCallback, but it returns an error if the
+// initial value can be neither read from consul within Options.TimeOut nor from the local
+// cache, the callback stays registered and receives the value once the watch recovers.
+func (c *client) RegisterConfigCallbackE(key string, uniqueID int64, callback func(string, ConfigParser)) error {
+	return c.RegisterConfigEventCallback(key, uniqueID, func(event ConfigEvent, parser ConfigParser) {
+		if event.Deleted {
 			return
 		}
-	}()
-	_, cancel := context.WithTimeout(context.Background(), c.consulTimeout)
+		callback(event.Value, parser)
+	})
+}
+
+// RegisterConfigEventCallback is like RegisterConfigCallbackE, but the callback receives
+// the events of the key with the consul metadata, including the deletion of the key.
+// The events are delivered in order, an event older than the one the callback has
+// received is dropped.
+func (c *client) RegisterConfigEventCallback(key string, uniqueID int64, callback func(ConfigEvent, ConfigParser)) error {
+	if declared, ok := c.chainOf(key); ok {
+		return c.registerChain(declared, uniqueID, callback)
+	}
+	return c.register(key, uniqueID, callback)
+}
+
+// register registers the callback on the single key.
+func (c *client) register(key string, uniqueID int64, callback func(ConfigEvent, ConfigParser)) error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return ErrClientClosed
+	}
+	c.inflight.Add(1)
+	defer c.inflight.Done()
+	w, ok := c.watchers[key]
+	if !ok {
+		w = newConfigWatcher(key)
+		c.watchers[key] = w
+	}
+	callbacks := w.add(uniqueID, callback)
+	c.m.Unlock()
+	// the observers are called without the lock, so they can use the client.
+	c.observer.OnCallbacks(key, callbacks)
+	if !ok {
+		go c.watch(w)
+	}
+
+	// the key is already watched, reuse the latest value instead of querying consul again.
+	if event, loaded := w.load(); loaded {
+		w.notifyOne(uniqueID, event, c.parser)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.consulTimeout)
 	defer cancel()
-	kv := c.consulCli.KV()
-	get, _, err := kv.Get(key, nil)
+	get, dc, index, err := c.getKey(ctx, key)
 	if err != nil {
-		klog.Debugf("[consul] key: %s config get value failed", c.lconfig.Key)
+		err = wrapError(err)
+		c.observer.OnFetch(key, err)
+		klog.Debugf("[consul] key: %s config get value failed", key)
+		if c.serveSnapshot(w, uniqueID) {
+			return nil
+		}
+		return fmt.Errorf("get key %s from consul failed: %w", key, err)
+	}
+	c.observer.OnFetch(key, nil)
+	if get == nil || len(get.Value) == 0 {
+		// the missing key, or the one with an empty value, is reported as deleted, so the callback
+		// knows the key is loaded without a value.
+		w.notifyOne(uniqueID, ConfigEvent{Key: keyName(c.declaredKey(key)), ModifyIndex: index, Datacenter: dc, Deleted: true}, c.parser)
+		return nil
+	}
+	c.deliver(key, get, func(parser ConfigParser) {
+		w.notifyOne(uniqueID, newConfigEvent(get, dc), parser)
+	})
+	return nil
+}
+
+// deliver passes the pair of the key identified by id to the callbacks, the value is saved
+// to the local cache if any of the callbacks decodes it successfully.
+func (c *client) deliver(id string, pair *api.KVPair, callbacks func(ConfigParser)) {
+	if c.cache == nil {
+		callbacks(c.parser)
 		return
 	}
-	if get == nil {
+	if index, ok := c.cache.recovered(id); ok {
+		if index > pair.ModifyIndex {
+			klog.Warnf("[consul] key: %s the local snapshot(index %d) is newer than consul(index %d), switch back to consul anyway", id, index, pair.ModifyIndex)
+		} else {
+			klog.Infof("[consul] key: %s switch from the local snapshot(index %d) back to consul(index %d)", id, index, pair.ModifyIndex)
+		}
+	}
+	tracker := &decodeTracker{ConfigParser: c.parser}
+	callbacks(tracker)
+	if !tracker.decoded.Load() {
 		return
 	}
-	if get.Value == nil {
+	if err := c.cache.save(id, string(pair.Value), pair.ModifyIndex, pair.Flags); err != nil {
+		klog.Warnf("[consul] key: %s save local snapshot failed: %s", id, err)
+	}
+}
+
+// serveSnapshot passes the local snapshot of the key to the callback of the uniqueID when
+// consul is unreachable, it returns false if there is no snapshot of the key.
+func (c *client) serveSnapshot(w *configWatcher, uniqueID int64) bool {
+	key := w.key
+	if c.cache == nil {
+		return false
+	}
+	snapshot, err := c.cache.load(key)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warnf("[consul] key: %s load local snapshot failed: %s", key, err)
+		}
+		return false
+	}
+	klog.Warnf("[consul] key: %s consul is unreachable, use the local snapshot(index %d) saved at %s",
+		key, snapshot.ModifyIndex, snapshot.SavedAt.Format(time.RFC3339))
+	c.cache.markServed(key, snapshot.ModifyIndex)
+	declared := c.declaredKey(key)
+	event := ConfigEvent{
+		Key:          keyName(declared),
+		Value:        snapshot.Value,
+		ModifyIndex:  snapshot.ModifyIndex,
+		Flags:        snapshot.Flags,
+		Datacenter:   c.datacenters(declared)[0],
+		FromSnapshot: true,
+	}
+	w.notifyOne(uniqueID, event, c.parser)
+	return true
+}
+
+// removeSnapshot removes the local snapshot of the deleted key, so it won't be used on the next startup.
+func (c *client) removeSnapshot(key string) {
+	if c.cache == nil {
 		return
 	}
-	callback(string(get.Value), c.parser)
+	if err := c.cache.remove(key); err != nil && !os.IsNotExist(err) {
+		klog.Warnf("[consul] key: %s remove local snapshot failed: %s", key, err)
+	}
 }
 
-func (c *client) DeregisterConfig(key string, uniqueID int64) {
-	c.deregisterCancelFunc(key, uniqueID)
+// watch runs the watch loop of the watcher, it returns when the loop is stopped.
+func (c *client) watch(w *configWatcher) {
+	key := w.key
+	// the key is created in the preferred datacenter only if none of the datacenters has it.
+	ctx, cancel := context.WithTimeout(context.Background(), c.consulTimeout)
+	get, _, _, err := c.getKey(ctx, key)
+	cancel()
+	if err == nil && get == nil {
+		c.createMissingKey(key)
+	}
+	c.runLoop(&w.watchLoop, key, c.watchQuery(w), func(u uint64, i interface{}) {
+		dc := w.datacenter()
+		if kv, ok := i.(*api.KVPair); ok && len(kv.Value) == 0 {
+			// the key with an empty value is handled as a deleted one.
+			i = nil
+		}
+		if i == nil {
+			// the restarted watch reports the deleted key again.
+			if event, loaded := w.load(); !loaded || event.Deleted {
+				return
+			}
+			klog.Debugf("[consul] config key: %s deleted", key)
+			c.removeSnapshot(key)
+			c.observer.OnDeleted(key)
+			w.notify(ConfigEvent{Key: keyName(c.declaredKey(key)), ModifyIndex: u, Datacenter: dc, Deleted: true}, c.parser)
+			return
+		}
+		kv := i.(*api.KVPair)
+		if event, loaded := w.load(); loaded && event.Datacenter == dc && kv.ModifyIndex <= event.ModifyIndex {
+			// the index of the query is changed by the other keys.
+			return
+		}
+		v := string(kv.Value)
+		klog.Debugf("[consul] config key: %s updated,value is %s", key, v)
+		c.observer.OnUpdate(key, newConfigEvent(kv, dc))
+		c.deliver(key, kv, func(parser ConfigParser) {
+			w.notify(newConfigEvent(kv, dc), parser)
+		})
+	})
+}
+
+// Observer returns the observers registered by Options.Observers, the categories report
+// the decode and apply results through it.
+func (c *client) Observer() Observer {
+	return c.observer
 }
 
-func (c *client) deregisterCancelFunc(key string, uniqueID int64) {
+// Health returns the health of the watch of the key or prefix, it returns false if the key
+// or prefix isn't watched.
+func (c *client) Health(key string) (HealthStatus, bool) {
 	c.m.Lock()
-	clientKey := key + "/" + strconv.FormatInt(uniqueID, 10)
-	cancel := c.cancelMap[clientKey]
-	cancel()
-	delete(c.cancelMap, clientKey)
+	defer c.m.Unlock()
+	if w, ok := c.watchers[key]; ok {
+		return w.healthStatus(), true
+	}
+	if w, ok := c.prefixWatchers[key]; ok {
+		return w.healthStatus(), true
+	}
+	return HealthStatus{}, false
+}
+
+// acquire marks a watch handler in flight, it returns false if the client is closed.
+func (c *client) acquire() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
+
+// Close stops all the consul watches and waits for the callbacks in flight until ctx is done.
+// The registrations after Close fail with ErrClientClosed, and it's safe to call Close more than once.
+func (c *client) Close(ctx context.Context) error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	watchers, prefixWatchers := c.watchers, c.prefixWatchers
+	c.watchers = make(map[string]*configWatcher)
+	c.prefixWatchers = make(map[string]*prefixWatcher)
 	c.m.Unlock()
+
+	for _, w := range watchers {
+		w.stop()
+	}
+	for _, w := range prefixWatchers {
+		w.stop()
+	}
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (c *client) registerCancelFunc(key string, uniqueID int64, cancel context.CancelFunc) {
+// DeregisterConfig deregister the callback of the uniqueID, the consul watch of
+// the key is stopped when the last callback is deregistered. It's a no-op if the
+// callback isn't registered.
+func (c *client) DeregisterConfig(key string, uniqueID int64) {
+	if c.deregisterChain(key, uniqueID) {
+		return
+	}
+	c.deregister(key, uniqueID)
+}
+
+// deregister deregisters the callback on the single key.
+func (c *client) deregister(key string, uniqueID int64) {
 	c.m.Lock()
-	clientKey := key + "/" + strconv.FormatInt(uniqueID, 10)
-	c.cancelMap[clientKey] = cancel
+	w, ok := c.watchers[key]
+	if !ok {
+		c.m.Unlock()
+		return
+	}
+	remaining := w.remove(uniqueID)
+	if remaining == 0 {
+		delete(c.watchers, key)
+		w.stop()
+	}
 	c.m.Unlock()
+	c.observer.OnCallbacks(key, remaining)
 }
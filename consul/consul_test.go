@@ -0,0 +1,86 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestClientClose(t *testing.T) {
+	cli, err := NewClient(Options{})
+	test.Assert(t, err == nil)
+
+	// deregistering an unknown callback is a no-op.
+	cli.DeregisterConfig("KitexConfig/ServiceName/limit", AllocateUniqueID())
+	cli.DeregisterPrefix("KitexConfig/ServiceName", AllocateUniqueID())
+
+	test.Assert(t, cli.Close(context.Background()) == nil)
+	test.Assert(t, cli.Close(context.Background()) == nil)
+
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/limit", AllocateUniqueID(), func(string, ConfigParser) {})
+	test.Assert(t, errors.Is(err, ErrClientClosed))
+	err = cli.RegisterPrefixCallback("KitexConfig/ServiceName", AllocateUniqueID(), func(map[string]string, PrefixDiff, ConfigParser) {})
+	test.Assert(t, errors.Is(err, ErrClientClosed))
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	// the agent accepts the requests but never responds.
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	cli, err := NewClient(Options{Addr: strings.TrimPrefix(srv.URL, "http://"), TimeOut: 100 * time.Millisecond})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	start := time.Now()
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/limit", AllocateUniqueID(), func(string, ConfigParser) {})
+	test.Assert(t, err != nil)
+	test.Assert(t, time.Since(start) < 5*time.Second, time.Since(start))
+}
+
+func TestRegisterPrefixTimeout(t *testing.T) {
+	// the agent accepts the requests but never responds.
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	cli, err := NewClient(Options{Addr: strings.TrimPrefix(srv.URL, "http://"), TimeOut: 100 * time.Millisecond})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	start := time.Now()
+	err = cli.RegisterPrefixCallback("KitexConfig/ServiceName", AllocateUniqueID(), func(map[string]string, PrefixDiff, ConfigParser) {})
+	test.Assert(t, err != nil)
+	test.Assert(t, time.Since(start) < 5*time.Second, time.Since(start))
+}
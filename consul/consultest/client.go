@@ -0,0 +1,486 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consultest provides an in-memory consul.Client and a consul KV HTTP server
+// for testing without a consul agent.
+package consultest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/kitex-contrib/config-consul/consul"
+)
+
+var _ consul.Client = &Client{}
+
+type subscriber struct {
+	callback  func(consul.ConfigEvent, consul.ConfigParser)
+	value     string
+	index     uint64
+	delivered bool
+}
+
+type prefixSubscriber struct {
+	callback func(map[string]string, consul.PrefixDiff, consul.ConfigParser)
+	values   map[string]string
+}
+
+type entry struct {
+	value       string
+	createIndex uint64
+	modifyIndex uint64
+	flags       uint64
+}
+
+// Client is an in-memory consul.Client. The values are changed by Set and Delete, and the
+// callbacks are called asynchronously in order, Settle waits for them to finish.
+type Client struct {
+	prefix           string
+	serverPathFormat string
+	clientPathFormat string
+
+	observer consul.Observers
+
+	mu                sync.Mutex
+	parser            consul.ConfigParser
+	configType        consul.ConfigType
+	token             string
+	index             uint64
+	kvs               map[string]*entry
+	subscribers       map[string]map[int64]*subscriber
+	prefixSubscribers map[string]map[int64]*prefixSubscriber
+	closed            bool
+
+	// dispatchMu serializes the deliveries, so the callbacks receive the events one by one.
+	dispatchMu sync.Mutex
+
+	queueMu sync.Mutex
+	queue   []func()
+	running bool
+	pending int
+	settled *sync.Cond
+}
+
+// NewClient creates the in-memory client, the Prefix, ServerPathFormat, ClientPathFormat
+// ConfigParser and ConfigType of the options are used as consul.NewClient does.
+func NewClient(opts consul.Options) (*Client, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = consul.ConsulDefaultConfiGPrefix
+	}
+	if opts.ServerPathFormat == "" {
+		opts.ServerPathFormat = consul.ConsulDefaultServerPath
+	}
+	if opts.ClientPathFormat == "" {
+		opts.ClientPathFormat = consul.ConsulDefaultClientPath
+	}
+	if opts.ConfigParser == nil {
+		opts.ConfigParser = consul.DefaultConfigParser()
+	}
+	if opts.ConfigType == "" {
+		opts.ConfigType = consul.JSON
+	}
+	// the invalid templates are rejected here as consul.NewClient does.
+	for _, format := range []string{opts.Prefix, opts.ServerPathFormat, opts.ClientPathFormat} {
+		if _, err := consul.RenderPath(format, &consul.ConfigParamConfig{}); err != nil {
+			return nil, err
+		}
+	}
+	c := &Client{
+		prefix:            opts.Prefix,
+		serverPathFormat:  opts.ServerPathFormat,
+		clientPathFormat:  opts.ClientPathFormat,
+		observer:          opts.Observers,
+		parser:            opts.ConfigParser,
+		configType:        opts.ConfigType,
+		kvs:               make(map[string]*entry),
+		subscribers:       make(map[string]map[int64]*subscriber),
+		prefixSubscribers: make(map[string]map[int64]*prefixSubscriber),
+	}
+	c.settled = sync.NewCond(&c.queueMu)
+	return c, nil
+}
+
+// Set puts the value of the key and notifies the callbacks watching the key.
+func (c *Client) Set(key, value string) {
+	c.SetWithFlags(key, value, 0)
+}
+
+// SetWithFlags is like Set, and sets the flags of the key as well.
+func (c *Client) SetWithFlags(key, value string, flags uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index++
+	e, ok := c.kvs[key]
+	if !ok {
+		e = &entry{createIndex: c.index}
+		c.kvs[key] = e
+	}
+	e.value, e.modifyIndex, e.flags = value, c.index, flags
+	c.notifyLocked(key, consul.ConfigEvent{
+		Key:         key,
+		Value:       value,
+		ModifyIndex: e.modifyIndex,
+		CreateIndex: e.createIndex,
+		Flags:       flags,
+	})
+}
+
+// Delete deletes the key and notifies the callbacks watching the key.
+func (c *Client) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.kvs[key]; !ok {
+		return
+	}
+	c.index++
+	delete(c.kvs, key)
+	c.notifyLocked(key, consul.ConfigEvent{Key: key, ModifyIndex: c.index, Deleted: true})
+}
+
+// Get returns the value of the key.
+func (c *Client) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.kvs[key]
+	if !ok {
+		return "", false
+	}
+	return e.value, true
+}
+
+// Registered returns the number of the callbacks registered on the key.
+func (c *Client) Registered(key string) int {
+	key = keyName(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.subscribers[key])
+}
+
+// Settle blocks until all the callbacks of the previous changes are called.
+func (c *Client) Settle() {
+	c.queueMu.Lock()
+	for c.pending > 0 {
+		c.settled.Wait()
+	}
+	c.queueMu.Unlock()
+}
+
+// notifyLocked queues the deliveries of the change, it must be called with mu held.
+func (c *Client) notifyLocked(key string, event consul.ConfigEvent) {
+	parser := c.parser
+	if len(c.subscribers[key]) > 0 {
+		// only the watched keys are observed, as consul.Client does.
+		c.enqueue(func() {
+			if event.Deleted {
+				c.observer.OnDeleted(key)
+			} else {
+				c.observer.OnUpdate(key, event)
+			}
+		})
+	}
+	for _, sub := range c.subscribers[key] {
+		sub := sub
+		c.enqueue(func() {
+			c.deliver(sub, event, parser)
+		})
+	}
+	for prefix, subs := range c.prefixSubscribers {
+		if !strings.HasPrefix(key, strings.TrimSuffix(prefix, "/")+"/") {
+			continue
+		}
+		values := c.subtreeLocked(prefix)
+		for _, sub := range subs {
+			sub := sub
+			c.enqueue(func() {
+				c.deliverPrefix(sub, values, parser)
+			})
+		}
+	}
+}
+
+func (c *Client) enqueue(f func()) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	c.queue = append(c.queue, f)
+	c.pending++
+	if !c.running {
+		c.running = true
+		go c.drain()
+	}
+}
+
+func (c *Client) drain() {
+	for {
+		c.queueMu.Lock()
+		if len(c.queue) == 0 {
+			c.running = false
+			c.queueMu.Unlock()
+			return
+		}
+		f := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queueMu.Unlock()
+
+		f()
+
+		c.queueMu.Lock()
+		c.pending--
+		if c.pending == 0 {
+			c.settled.Broadcast()
+		}
+		c.queueMu.Unlock()
+	}
+}
+
+// deliver calls the callback unless it has already received the event or a newer one.
+func (c *Client) deliver(sub *subscriber, event consul.ConfigEvent, parser consul.ConfigParser) {
+	c.dispatchMu.Lock()
+	defer c.dispatchMu.Unlock()
+	if sub.delivered && event.ModifyIndex <= sub.index {
+		return
+	}
+	event.PrevValue = sub.value
+	sub.value, sub.index, sub.delivered = event.Value, event.ModifyIndex, true
+	sub.callback(event, consul.EventParser(parser, event))
+}
+
+func (c *Client) deliverPrefix(sub *prefixSubscriber, values map[string]string, parser consul.ConfigParser) {
+	c.dispatchMu.Lock()
+	defer c.dispatchMu.Unlock()
+	diff := consul.DiffValues(sub.values, values)
+	if sub.values != nil && diff.Empty() {
+		return
+	}
+	sub.values = values
+	sub.callback(consul.CopyValues(values), diff, parser)
+}
+
+// subtreeLocked returns the values under the prefix, the prefix is bounded at "/" like consul.Client.
+func (c *Client) subtreeLocked(prefix string) map[string]string {
+	values := make(map[string]string)
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	for k, e := range c.kvs {
+		if rest, ok := strings.CutPrefix(k, prefix); ok && !strings.HasSuffix(k, "/") {
+			values[rest] = e.value
+		}
+	}
+	return values
+}
+
+// SetParser implements consul.Client.
+func (c *Client) SetParser(parser consul.ConfigParser) {
+	c.mu.Lock()
+	c.parser = parser
+	c.mu.Unlock()
+}
+
+// SetToken implements consul.Client, the token is only recorded.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// Health implements consul.Client, the watches of the in-memory client are always healthy.
+func (c *Client) Health(key string) (consul.HealthStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, watched := c.subscribers[keyName(key)]
+	if !watched {
+		_, watched = c.prefixSubscribers[key]
+	}
+	if !watched {
+		return consul.HealthStatus{}, false
+	}
+	return consul.HealthStatus{State: consul.WatchHealthy, Index: c.index}, true
+}
+
+// DeclareKey implements consul.Client, the in-memory client never creates the missing keys
+// and doesn't merge the fallbacks of the keys, the locations of the keys are ignored as well.
+func (c *Client) DeclareKey(consul.Key) {}
+
+// keyName returns the key of the consul.Key identified by id, the location is ignored.
+func keyName(id string) string {
+	name, _, _ := strings.Cut(id, "?")
+	return name
+}
+
+// Observer implements consul.Client, it returns the observers of the options.
+func (c *Client) Observer() consul.Observer {
+	return c.observer
+}
+
+// Token returns the token set by SetToken.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// ClientConfigParam implements consul.Client.
+func (c *Client) ClientConfigParam(cpc *consul.ConfigParamConfig, cfs ...consul.CustomFunction) (consul.Key, error) {
+	return c.configParam(cpc, c.clientPathFormat, cfs...)
+}
+
+// ServerConfigParam implements consul.Client.
+func (c *Client) ServerConfigParam(cpc *consul.ConfigParamConfig, cfs ...consul.CustomFunction) (consul.Key, error) {
+	return c.configParam(cpc, c.serverPathFormat, cfs...)
+}
+
+func (c *Client) configParam(cpc *consul.ConfigParamConfig, format string, cfs ...consul.CustomFunction) (consul.Key, error) {
+	param := consul.Key{Type: c.configType, Category: cpc.Category}
+	var err error
+	param.Path, err = consul.RenderPath(format, cpc)
+	if err != nil {
+		return param, err
+	}
+	param.Prefix, err = consul.RenderPath(c.prefix, cpc)
+	if err != nil {
+		return param, err
+	}
+	for _, cf := range cfs {
+		cf(&param)
+	}
+	return param, nil
+}
+
+// RegisterConfigCallback implements consul.Client.
+func (c *Client) RegisterConfigCallback(key string, uniqueID int64, callback func(string, consul.ConfigParser)) {
+	c.RegisterConfigCallbackE(key, uniqueID, callback)
+}
+
+// RegisterConfigCallbackE implements consul.Client.
+func (c *Client) RegisterConfigCallbackE(key string, uniqueID int64, callback func(string, consul.ConfigParser)) error {
+	return c.RegisterConfigEventCallback(key, uniqueID, func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		if event.Deleted {
+			return
+		}
+		callback(event.Value, parser)
+	})
+}
+
+// RegisterConfigEventCallback implements consul.Client, the current value of the key, or its
+// absence, is passed to the callback before it returns.
+func (c *Client) RegisterConfigEventCallback(key string, uniqueID int64, callback func(consul.ConfigEvent, consul.ConfigParser)) error {
+	key = keyName(key)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return consul.ErrClientClosed
+	}
+	sub := &subscriber{callback: callback}
+	if c.subscribers[key] == nil {
+		c.subscribers[key] = make(map[int64]*subscriber)
+	}
+	c.subscribers[key][uniqueID] = sub
+	callbacks := len(c.subscribers[key])
+	// the missing key is reported as deleted, as consul.Client does.
+	event := consul.ConfigEvent{Key: key, ModifyIndex: c.index, Deleted: true}
+	if e, ok := c.kvs[key]; ok {
+		event = consul.ConfigEvent{
+			Key:         key,
+			Value:       e.value,
+			ModifyIndex: e.modifyIndex,
+			CreateIndex: e.createIndex,
+			Flags:       e.flags,
+		}
+	}
+	parser := c.parser
+	c.mu.Unlock()
+
+	c.observer.OnCallbacks(key, callbacks)
+	c.observer.OnFetch(key, nil)
+	c.deliver(sub, event, parser)
+	return nil
+}
+
+// DeregisterConfig implements consul.Client.
+func (c *Client) DeregisterConfig(key string, uniqueID int64) {
+	key = keyName(key)
+	c.mu.Lock()
+	if _, ok := c.subscribers[key][uniqueID]; !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.subscribers[key], uniqueID)
+	remaining := len(c.subscribers[key])
+	if remaining == 0 {
+		delete(c.subscribers, key)
+	}
+	c.mu.Unlock()
+	c.observer.OnCallbacks(key, remaining)
+}
+
+// RegisterPrefixCallback implements consul.Client, the current subtree of the prefix is
+// passed to the callback before it returns.
+func (c *Client) RegisterPrefixCallback(prefix string, uniqueID int64, callback func(map[string]string, consul.PrefixDiff, consul.ConfigParser)) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return consul.ErrClientClosed
+	}
+	sub := &prefixSubscriber{callback: callback}
+	if c.prefixSubscribers[prefix] == nil {
+		c.prefixSubscribers[prefix] = make(map[int64]*prefixSubscriber)
+	}
+	c.prefixSubscribers[prefix][uniqueID] = sub
+	callbacks := len(c.prefixSubscribers[prefix])
+	values := c.subtreeLocked(prefix)
+	parser := c.parser
+	c.mu.Unlock()
+
+	c.observer.OnCallbacks(prefix, callbacks)
+	c.observer.OnFetch(prefix, nil)
+	c.deliverPrefix(sub, values, parser)
+	return nil
+}
+
+// DeregisterPrefix implements consul.Client.
+func (c *Client) DeregisterPrefix(prefix string, uniqueID int64) {
+	c.mu.Lock()
+	if _, ok := c.prefixSubscribers[prefix][uniqueID]; !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.prefixSubscribers[prefix], uniqueID)
+	remaining := len(c.prefixSubscribers[prefix])
+	if remaining == 0 {
+		delete(c.prefixSubscribers, prefix)
+	}
+	c.mu.Unlock()
+	c.observer.OnCallbacks(prefix, remaining)
+}
+
+// Close implements consul.Client, it waits for the queued callbacks until ctx is done.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	c.subscribers = make(map[string]map[int64]*subscriber)
+	c.prefixSubscribers = make(map[string]map[int64]*prefixSubscriber)
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.Settle()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consultest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+)
+
+const testKey = "KitexConfig/ClientName/ServiceName/retry"
+
+func TestClient(t *testing.T) {
+	cli, err := NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+
+	key, err := cli.ClientConfigParam(&consul.ConfigParamConfig{
+		Category:          "retry",
+		ClientServiceName: "ClientName",
+		ServerServiceName: "ServiceName",
+	})
+	test.Assert(t, err == nil)
+	test.Assert(t, key.Type == consul.JSON)
+	test.Assert(t, key.Prefix+"/"+key.Path == testKey, key.Prefix, key.Path)
+
+	cli.Set(testKey, "v1")
+	var events []consul.ConfigEvent
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events = append(events, event)
+	})
+	test.Assert(t, err == nil)
+	// the current value is delivered before the registration returns.
+	test.Assert(t, len(events) == 1 && events[0].Value == "v1")
+
+	cli.Set(testKey, "v2")
+	cli.Delete(testKey)
+	cli.Set(testKey, "v3")
+	cli.Settle()
+	test.Assert(t, len(events) == 4, len(events))
+	test.Assert(t, events[1].Value == "v2" && events[1].PrevValue == "v1")
+	test.Assert(t, events[2].Deleted && events[2].PrevValue == "v2")
+	test.Assert(t, events[3].Value == "v3" && events[3].CreateIndex == events[3].ModifyIndex)
+
+	cli.DeregisterConfig(testKey, 1)
+	test.Assert(t, cli.Registered(testKey) == 0)
+	cli.Set(testKey, "v4")
+	cli.Settle()
+	test.Assert(t, len(events) == 4)
+
+	test.Assert(t, cli.Close(context.Background()) == nil)
+	err = cli.RegisterConfigCallbackE(testKey, 2, func(string, consul.ConfigParser) {})
+	test.Assert(t, errors.Is(err, consul.ErrClientClosed))
+}
+
+func TestClientPrefix(t *testing.T) {
+	cli, err := NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+
+	cli.Set("KitexConfig/a", "1")
+	var diffs []consul.PrefixDiff
+	var values map[string]string
+	err = cli.RegisterPrefixCallback("KitexConfig", 1, func(v map[string]string, diff consul.PrefixDiff, _ consul.ConfigParser) {
+		values = v
+		diffs = append(diffs, diff)
+	})
+	test.Assert(t, err == nil)
+	test.Assert(t, reflect.DeepEqual(values, map[string]string{"a": "1"}))
+
+	cli.Set("KitexConfig/b", "2")
+	cli.Set("KitexConfig/a", "1")
+	cli.Delete("KitexConfig/a")
+	cli.Set("Other/c", "3")
+	cli.Set("KitexConfigX/d", "4")
+	cli.Settle()
+	test.Assert(t, len(diffs) == 3, len(diffs))
+	test.Assert(t, reflect.DeepEqual(diffs[1].Added, []string{"b"}))
+	test.Assert(t, reflect.DeepEqual(diffs[2].Removed, []string{"a"}))
+	test.Assert(t, reflect.DeepEqual(values, map[string]string{"b": "2"}))
+}
+
+func TestServer(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	cli, err := consul.NewClient(consul.Options{Addr: srv.Addr()})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+
+	events := make(chan consul.ConfigEvent, 10)
+	srv.Set(testKey, `{"a":1}`)
+	err = cli.RegisterConfigEventCallback(testKey, 1, func(event consul.ConfigEvent, _ consul.ConfigParser) {
+		events <- event
+	})
+	test.Assert(t, err == nil)
+	event := receive(t, events)
+	test.Assert(t, event.Value == `{"a":1}`, event.Value)
+
+	srv.Set(testKey, `{"a":2}`)
+	event = receive(t, events)
+	test.Assert(t, event.Value == `{"a":2}` && event.PrevValue == `{"a":1}`, event.Value)
+
+	srv.Delete(testKey)
+	event = receive(t, events)
+	test.Assert(t, event.Deleted)
+
+	srv.SetAvailable(false)
+	err = cli.RegisterConfigCallbackE("KitexConfig/ServiceName/limit", 2, func(string, consul.ConfigParser) {})
+	test.Assert(t, err != nil)
+}
+
+func receive(t *testing.T, events chan consul.ConfigEvent) consul.ConfigEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the config event")
+	}
+	return consul.ConfigEvent{}
+}
@@ -0,0 +1,444 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consultest
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	kvPath = "/v1/kv/"
+
+	defaultWait = 5 * time.Minute
+
+	defaultDatacenter = "dc1"
+	defaultNamespace  = "default"
+	defaultPartition  = "default"
+)
+
+// Location is the datacenter, namespace and partition of the keys, the empty fields are the
+// defaults of the server: dc1, the default namespace and the default partition.
+type Location struct {
+	Datacenter string
+	Namespace  string
+	Partition  string
+}
+
+// requestLocation returns the location of the request from its query parameters.
+func requestLocation(r *http.Request) Location {
+	query := r.URL.Query()
+	return Location{
+		Datacenter: query.Get("dc"),
+		Namespace:  query.Get("ns"),
+		Partition:  query.Get("partition"),
+	}
+}
+
+// normalize fills the empty fields with the defaults.
+func (l Location) normalize() Location {
+	if l.Datacenter == "" {
+		l.Datacenter = defaultDatacenter
+	}
+	if l.Namespace == "" {
+		l.Namespace = defaultNamespace
+	}
+	if l.Partition == "" {
+		l.Partition = defaultPartition
+	}
+	return l
+}
+
+// storeKey returns the key of the KV store, the keys of the locations are stored apart.
+func (l Location) storeKey(key string) string {
+	l = l.normalize()
+	return l.Datacenter + "/" + l.Partition + "/" + l.Namespace + "/" + key
+}
+
+// Server is a consul agent stub serving the KV HTTP API from memory, including the
+// blocking queries, so the real consul client can be tested against it.
+type Server struct {
+	*cluster
+	srv *httptest.Server
+	tls bool
+
+	// available is guarded by cluster.mu.
+	available bool
+}
+
+// cluster is the state shared by the agents created by NewAgent.
+type cluster struct {
+	mu    sync.Mutex
+	index uint64
+	// kvs is keyed by Location.storeKey.
+	kvs      map[string]*api.KVPair
+	username string
+	password string
+	token    string
+	// unreachable is the datacenters failing the requests.
+	unreachable map[string]bool
+	// changed is closed and replaced on every change to wake up the blocking queries.
+	changed chan struct{}
+}
+
+// NewServer starts the server, it must be closed by Close.
+func NewServer() *Server {
+	return newAgent(newCluster(), false)
+}
+
+// NewTLSServer starts the server serving https, the certificate of the server is
+// returned by CAPem. It must be closed by Close.
+func NewTLSServer() *Server {
+	return newAgent(newCluster(), true)
+}
+
+// NewAgent starts another agent of the same cluster, the agents share the KV store and the
+// index, so the consul client can fail over between them. It must be closed by Close.
+func (s *Server) NewAgent() *Server {
+	return newAgent(s.cluster, s.tls)
+}
+
+func newCluster() *cluster {
+	return &cluster{
+		index:       1,
+		kvs:         make(map[string]*api.KVPair),
+		unreachable: make(map[string]bool),
+		changed:     make(chan struct{}),
+	}
+}
+
+func newAgent(c *cluster, tls bool) *Server {
+	s := &Server{cluster: c, tls: tls, available: true}
+	if tls {
+		s.srv = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	} else {
+		s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	}
+	return s
+}
+
+// CAPem returns the PEM-encoded certificate of the TLS server, it's nil for the plain http server.
+func (s *Server) CAPem() []byte {
+	cert := s.srv.Certificate()
+	if cert == nil {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// SetBasicAuth requires the requests to carry the HTTP basic auth credential,
+// an empty username disables the check.
+func (s *Server) SetBasicAuth(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetToken requires the requests to carry the ACL token, the requests with another token
+// are rejected with 403. An empty token disables the check.
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Addr returns the host:port of the server, it's used as consul.Options.Addr.
+func (s *Server) Addr() string {
+	addr := strings.TrimPrefix(s.srv.URL, "http://")
+	return strings.TrimPrefix(addr, "https://")
+}
+
+// Close shuts down the server, the other agents of the cluster keep running.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.available = false
+	s.wakeLocked()
+	s.mu.Unlock()
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// Set puts the value of the key in the default location.
+func (s *Server) Set(key, value string) {
+	s.SetIn(Location{}, key, value)
+}
+
+// SetIn puts the value of the key in the location.
+func (s *Server) SetIn(loc Location, key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(loc, key, []byte(value), 0)
+}
+
+// Delete deletes the key in the default location.
+func (s *Server) Delete(key string) {
+	s.DeleteIn(Location{}, key)
+}
+
+// DeleteIn deletes the key in the location.
+func (s *Server) DeleteIn(loc Location, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kvs[loc.storeKey(key)]; ok {
+		delete(s.kvs, loc.storeKey(key))
+		s.index++
+		s.wakeLocked()
+	}
+}
+
+// Get returns the value of the key in the default location.
+func (s *Server) Get(key string) (string, bool) {
+	return s.GetIn(Location{}, key)
+}
+
+// GetIn returns the value of the key in the location.
+func (s *Server) GetIn(loc Location, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pair, ok := s.kvs[loc.storeKey(key)]
+	if !ok {
+		return "", false
+	}
+	return string(pair.Value), true
+}
+
+// Changed returns a channel closed on the next change of the keys or the availability, e.g. to
+// wait for a key written by the client without polling.
+func (s *Server) Changed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// SetAvailable switches the server between serving the requests and failing them
+// with 500, the blocking queries are woken up when the server becomes unavailable.
+// The other agents of the cluster aren't affected.
+func (s *Server) SetAvailable(available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.available = available
+	s.wakeLocked()
+}
+
+// SetDatacenterAvailable switches the datacenter of the cluster between serving the requests
+// and failing them with 500 like an unreachable datacenter, the blocking queries are woken up
+// when the datacenter becomes unavailable.
+func (s *Server) SetDatacenterAvailable(dc string, available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unreachable[dc] = !available
+	s.wakeLocked()
+}
+
+// availableLocked returns true if the agent and the datacenter of the location serve the requests.
+func (s *Server) availableLocked(loc Location) bool {
+	return s.available && !s.unreachable[loc.normalize().Datacenter]
+}
+
+func (s *cluster) putLocked(loc Location, key string, value []byte, flags uint64) {
+	s.index++
+	pair, ok := s.kvs[loc.storeKey(key)]
+	if !ok {
+		pair = &api.KVPair{Key: key, CreateIndex: s.index}
+		s.kvs[loc.storeKey(key)] = pair
+	}
+	pair.Value, pair.Flags, pair.ModifyIndex = value, flags, s.index
+	s.wakeLocked()
+}
+
+func (s *cluster) wakeLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, kvPath) {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !s.permitted(r) {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, kvPath)
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGet(w, r, key)
+	case http.MethodPut:
+		s.handlePut(w, r, key)
+	case http.MethodDelete:
+		s.handleDelete(w, r, key)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.username == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	return ok && username == s.username && password == s.password
+}
+
+func (s *Server) permitted(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == "" {
+		return true
+	}
+	token := r.Header.Get("X-Consul-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return token == s.token
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	_, recurse := query["recurse"]
+	minIndex, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+	wait := defaultWait
+	if v := query.Get("wait"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			wait = d
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	loc := requestLocation(r)
+	s.mu.Lock()
+	for s.availableLocked(loc) && minIndex > 0 && s.index <= minIndex {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			minIndex = 0
+		case <-r.Context().Done():
+			return
+		}
+		s.mu.Lock()
+	}
+	if !s.availableLocked(loc) {
+		s.mu.Unlock()
+		http.Error(w, "consul is unavailable", http.StatusInternalServerError)
+		return
+	}
+	var pairs api.KVPairs
+	if recurse {
+		for k, pair := range s.kvs {
+			if strings.HasPrefix(k, loc.storeKey(key)) {
+				pairs = append(pairs, copyPair(pair))
+			}
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	} else if pair, ok := s.kvs[loc.storeKey(key)]; ok {
+		pairs = append(pairs, copyPair(pair))
+	}
+	index := s.index
+	s.mu.Unlock()
+
+	setHeaders(w, index)
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.availableLocked(requestLocation(r)) {
+		http.Error(w, "consul is unavailable", http.StatusInternalServerError)
+		return
+	}
+	if v := query.Get("cas"); v != "" {
+		cas, _ := strconv.ParseUint(v, 10, 64)
+		pair, ok := s.kvs[requestLocation(r).storeKey(key)]
+		if (cas == 0 && ok) || (cas != 0 && (!ok || pair.ModifyIndex != cas)) {
+			setHeaders(w, s.index)
+			io.WriteString(w, "false")
+			return
+		}
+	}
+	s.putLocked(requestLocation(r), key, value, flags)
+	setHeaders(w, s.index)
+	io.WriteString(w, "true")
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
+	_, recurse := r.URL.Query()["recurse"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.availableLocked(requestLocation(r)) {
+		http.Error(w, "consul is unavailable", http.StatusInternalServerError)
+		return
+	}
+	deleted := false
+	key = requestLocation(r).storeKey(key)
+	for k := range s.kvs {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			delete(s.kvs, k)
+			deleted = true
+		}
+	}
+	if deleted {
+		s.index++
+		s.wakeLocked()
+	}
+	setHeaders(w, s.index)
+	io.WriteString(w, "true")
+}
+
+func setHeaders(w http.ResponseWriter, index uint64) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.Header().Set("X-Consul-LastContact", "0")
+}
+
+func copyPair(pair *api.KVPair) *api.KVPair {
+	out := *pair
+	out.Value = append([]byte(nil), pair.Value...)
+	return &out
+}
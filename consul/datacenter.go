@@ -0,0 +1,130 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/hashicorp/consul/api"
+)
+
+// datacenterProbeInterval is how often the watch of a key served by a fallback datacenter
+// checks whether the preferred datacenters have the key again.
+var datacenterProbeInterval = time.Minute
+
+// datacenters returns the datacenters of the key in order of preference, the datacenter of
+// the key, or of the client, followed by the fallback datacenters.
+func (c *client) datacenters(declared Key) []string {
+	local := declared.Datacenter
+	if local == "" {
+		local = c.lconfig.DataCenter
+	}
+	dcs := make([]string, 0, len(c.fallbackDataCenters)+1)
+	dcs = append(dcs, local)
+	for _, dc := range c.fallbackDataCenters {
+		if dc != local {
+			dcs = append(dcs, dc)
+		}
+	}
+	return dcs
+}
+
+// getIn reads the key from the datacenter with the options of the declared key.
+func (c *client) getIn(declared Key, key, dc string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	q = declared.queryOptions(q)
+	q.Datacenter = dc
+	return c.consulCli.KV().Get(key, q)
+}
+
+// getKey reads the key from the first datacenter having it. If none of them has the key, the
+// result of the preferred datacenter is returned, the pair is nil if the key is missing there.
+// The index is the one of the query returning the result. The reads are canceled once ctx is done.
+func (c *client) getKey(ctx context.Context, id string) (*api.KVPair, string, uint64, error) {
+	declared := c.declaredKey(id)
+	key := keyName(declared)
+	dcs := c.datacenters(declared)
+	var preferredIndex uint64
+	var preferredErr error
+	for i, dc := range dcs {
+		pair, meta, err := c.getIn(declared, key, dc, c.queryOptions().WithContext(ctx))
+		if err == nil && pair != nil {
+			if i > 0 {
+				klog.Warnf("[consul] key: %s isn't available in datacenter %s, read it from datacenter %s", key, dcs[0], dc)
+			}
+			return pair, dc, meta.LastIndex, nil
+		}
+		if i == 0 {
+			preferredErr = err
+			if meta != nil {
+				preferredIndex = meta.LastIndex
+			}
+		}
+	}
+	return nil, dcs[0], preferredIndex, preferredErr
+}
+
+// watchQuery returns the blocking query of the watcher, it serves the key from the most preferred
+// datacenter having it. The key fails over to the next datacenters when it's missing or the
+// datacenter fails, and switches back once a preferred datacenter has the key again. The
+// blocking query only waits on the datacenter serving the key, as the indexes of the
+// datacenters aren't comparable, the other ones are read without blocking.
+func (c *client) watchQuery(w *configWatcher) queryFunc {
+	serving := 0
+	return func(q *api.QueryOptions) (interface{}, *api.QueryMeta, error) {
+		declared := c.declaredKey(w.key)
+		key := keyName(declared)
+		dcs := c.datacenters(declared)
+		if serving >= len(dcs) {
+			serving = 0
+		}
+		var preferredMeta *api.QueryMeta
+		var preferredErr error
+		for i, dc := range dcs {
+			dq := *q
+			if i != serving {
+				dq.WaitIndex = 0
+			} else if serving > 0 && (dq.WaitTime == 0 || dq.WaitTime > datacenterProbeInterval) {
+				dq.WaitTime = datacenterProbeInterval
+			}
+			pair, meta, err := c.getIn(declared, key, dc, &dq)
+			if err != nil && q.Context().Err() != nil {
+				// the query is canceled.
+				return nil, meta, err
+			}
+			if err == nil && pair != nil {
+				c.serveFrom(w, dcs, i)
+				serving = i
+				return pair, meta, nil
+			}
+			if i == 0 {
+				preferredMeta, preferredErr = meta, err
+			}
+		}
+		// none of the datacenters has the key, wait for it in the preferred one.
+		c.serveFrom(w, dcs, 0)
+		serving = 0
+		// the handler checks the missing key with a nil interface.
+		return nil, preferredMeta, preferredErr
+	}
+}
+
+// serveFrom records the datacenter serving the key of the watcher.
+func (c *client) serveFrom(w *configWatcher, dcs []string, i int) {
+	if prev := w.serve(dcs[i]); prev != "" && prev != dcs[i] {
+		klog.Warnf("[consul] key: %s switch from datacenter %s to datacenter %s", w.key, prev, dcs[i])
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// Auto detects the config type of every value of the key, see DetectConfigType.
+const Auto ConfigType = "auto"
+
+// The KV flags marking the config type of the values, e.g. `consul kv put -flags=2 key value`
+// for a YAML value. The other flags are left to the applications.
+const (
+	FlagsJSON uint64 = 1
+	FlagsYAML uint64 = 2
+	FlagsHCL  uint64 = 3
+)
+
+var flagsTypes = map[uint64]ConfigType{
+	FlagsJSON: JSON,
+	FlagsYAML: YAML,
+	FlagsHCL:  HCL,
+}
+
+var suffixTypes = map[string]ConfigType{
+	".json": JSON,
+	".yaml": YAML,
+	".yml":  YAML,
+	".hcl":  HCL,
+}
+
+// DetectConfigType returns the config type of the value of the key. The KV flags take
+// precedence, then the suffix of the key, e.g. ".yaml". Otherwise the type is sniffed from
+// the value: valid JSON is JSON, a valid HCL document is HCL and the rest is YAML.
+func DetectConfigType(key string, flags uint64, value string) ConfigType {
+	if configType, ok := flagsTypes[flags]; ok {
+		return configType
+	}
+	if configType, ok := suffixTypes[strings.ToLower(path.Ext(key))]; ok {
+		return configType
+	}
+	if strings.TrimSpace(value) == "" {
+		// the empty value is a valid empty document of YAML.
+		return YAML
+	}
+	if json.Valid([]byte(value)) {
+		return JSON
+	}
+	if _, err := hcl.ParseString(value); err == nil {
+		return HCL
+	}
+	return YAML
+}
+
+// eventParser decodes the values of the Auto type in the type detected from the event.
+type eventParser struct {
+	ConfigParser
+	event ConfigEvent
+}
+
+func (p eventParser) Decode(configType ConfigType, data string, config interface{}) error {
+	if configType == Auto {
+		configType = DetectConfigType(p.event.Key, p.event.Flags, data)
+	}
+	return p.ConfigParser.Decode(configType, data, config)
+}
+
+// EventParser returns the parser passed to the callbacks with the event, it decodes the
+// values of the Auto type in the type detected from the key and the flags of the event.
+func EventParser(parser ConfigParser, event ConfigEvent) ConfigParser {
+	return eventParser{ConfigParser: parser, event: event}
+}
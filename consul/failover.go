@@ -0,0 +1,156 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+const (
+	failoverMinBackoff = time.Second
+	failoverMaxBackoff = 30 * time.Second
+)
+
+// agentState is the health of a consul agent address.
+type agentState struct {
+	addr     string
+	failures int
+	// retryAt is the time before which the agent isn't used unless all the agents are down.
+	retryAt time.Time
+}
+
+// failoverTransport sends the requests to the current consul agent and fails over to the next
+// healthy one on connection errors and 5xx responses. A failed agent is skipped with an
+// exponential backoff. The KV client and the watches share the transport, so a blocking query
+// is retried against the next agent with the same index and no update is missed or repeated.
+type failoverTransport struct {
+	next http.RoundTripper
+
+	mu      sync.Mutex
+	agents  []*agentState
+	current int
+	now     func() time.Time
+}
+
+func newFailoverTransport(next http.RoundTripper, addrs []string) *failoverTransport {
+	agents := make([]*agentState, 0, len(addrs))
+	for _, addr := range addrs {
+		agents = append(agents, &agentState{addr: trimScheme(addr)})
+	}
+	return &failoverTransport{
+		next:   next,
+		agents: agents,
+		now:    time.Now,
+	}
+}
+
+// trimScheme removes the scheme of the address, the scheme is set by Options.Scheme.
+func trimScheme(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[i+3:]
+	}
+	return addr
+}
+
+// pick returns the current agent if it's healthy, otherwise the next healthy agent. The agent
+// to be retried soonest is returned if all the agents are down.
+func (t *failoverTransport) pick() *agentState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	soonest := t.current
+	for i := 0; i < len(t.agents); i++ {
+		idx := (t.current + i) % len(t.agents)
+		agent := t.agents[idx]
+		if !agent.retryAt.After(now) {
+			soonest = idx
+			break
+		}
+		if agent.retryAt.Before(t.agents[soonest].retryAt) {
+			soonest = idx
+		}
+	}
+	if soonest != t.current {
+		klog.Warnf("[consul] fail over from agent %s to %s", t.agents[t.current].addr, t.agents[soonest].addr)
+		t.current = soonest
+	}
+	return t.agents[soonest]
+}
+
+func (t *failoverTransport) succeed(agent *agentState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	agent.failures = 0
+	agent.retryAt = time.Time{}
+}
+
+func (t *failoverTransport) fail(agent *agentState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	backoff := failoverMinBackoff << agent.failures
+	if backoff > failoverMaxBackoff || backoff <= 0 {
+		backoff = failoverMaxBackoff
+	} else {
+		agent.failures++
+	}
+	agent.retryAt = t.now().Add(backoff)
+}
+
+// RoundTrip implements http.RoundTripper, every agent is tried at most once per request.
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the request can't be resent if its body can't be rewound.
+	attempts := len(t.agents)
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		attempts = 1
+	}
+	var resp *http.Response
+	var err error
+	for i := 0; i < attempts; i++ {
+		agent := t.pick()
+		r := req.Clone(req.Context())
+		r.URL.Host, r.Host = agent.addr, agent.addr
+		if i > 0 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.next.RoundTrip(r)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			t.succeed(agent)
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// the request is canceled, it's not the fault of the agent.
+			return resp, err
+		}
+		if err != nil {
+			klog.Warnf("[consul] request to agent %s failed: %s", agent.addr, err)
+		} else {
+			klog.Warnf("[consul] request to agent %s failed with status %d", agent.addr, resp.StatusCode)
+		}
+		t.fail(agent)
+		if i < attempts-1 && resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFailoverTransport(t *testing.T) {
+	down := map[string]bool{"a:8500": true}
+	var hosts []string
+	transport := newFailoverTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		if down[req.URL.Host] {
+			return nil, errors.New("connection refused")
+		}
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(req.Body)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+	}), []string{"http://a:8500", "b:8500"})
+	now := time.Now()
+	transport.now = func() time.Time { return now }
+
+	req, _ := http.NewRequest(http.MethodPut, "http://a:8500/v1/kv/key", strings.NewReader("value"))
+	resp, err := transport.RoundTrip(req)
+	test.Assert(t, err == nil, err)
+	body, _ := io.ReadAll(resp.Body)
+	// the body is resent to the second agent.
+	test.Assert(t, string(body) == "value")
+	test.Assert(t, strings.Join(hosts, ",") == "a:8500,b:8500", hosts)
+
+	// the failed agent is skipped during the backoff, even if it's back.
+	down["a:8500"] = false
+	hosts = nil
+	req, _ = http.NewRequest(http.MethodGet, "http://a:8500/v1/kv/key", nil)
+	_, err = transport.RoundTrip(req)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, strings.Join(hosts, ",") == "b:8500", hosts)
+
+	// all the agents are down, the agent to be retried soonest is used.
+	down["b:8500"] = true
+	now = now.Add(500 * time.Millisecond)
+	hosts = nil
+	_, err = transport.RoundTrip(req)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, strings.Join(hosts, ",") == "b:8500,a:8500", hosts)
+	test.Assert(t, transport.agents[1].failures == 1)
+	test.Assert(t, transport.agents[0].failures == 0)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/hcl"
+	hclparser "github.com/hashicorp/hcl/hcl/parser"
+)
+
+// decodeHCL decodes the HCL document into config. The document is converted to JSON first,
+// so the fields are mapped by the json tags of the kitex config structs, e.g.
+//
+//	"*" {
+//	  enable = true
+//	  type   = 0
+//	  failure_policy {
+//	    stop_policy {
+//	      max_retry_times = 3
+//	    }
+//	  }
+//	}
+func decodeHCL(data string, config interface{}) error {
+	file, err := hcl.ParseString(data)
+	if err != nil {
+		return hclError(err)
+	}
+	var doc map[string]interface{}
+	if err = hcl.DecodeObject(&doc, file); err != nil {
+		return hclError(err)
+	}
+	js, err := json.Marshal(flattenHCL(doc))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, config)
+}
+
+// flattenHCL unwraps the blocks of the HCL document. HCL decodes every block to a list of
+// objects, a block defined only once is an object in JSON.
+func flattenHCL(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = flattenHCL(item)
+		}
+		return val
+	case []map[string]interface{}:
+		if len(val) == 1 {
+			return flattenHCL(val[0])
+		}
+		out := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			out = append(out, flattenHCL(item))
+		}
+		return out
+	case []interface{}:
+		for i, item := range val {
+			val[i] = flattenHCL(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// hclError reports the line and column of the malformed HCL document.
+func hclError(err error) error {
+	var pe *hclparser.PosError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("hcl: line %d, column %d: %w", pe.Pos.Line, pe.Pos.Column, pe.Err)
+	}
+	return fmt.Errorf("hcl: %w", err)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"net/url"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ID returns the identity of the key, the callbacks of the key are registered and deregistered
+// with it. It's the consul key "Prefix/Path" if the location isn't overridden, otherwise the
+// datacenter, namespace and partition are appended as a query, e.g. "KitexConfig/Svc/retry?partition=central",
+// so the same path in different locations is watched and cached separately.
+func (k Key) ID() string {
+	return keyID(keyName(k), k)
+}
+
+// keyID returns the identity of the consul key in the location of the Key.
+func keyID(name string, k Key) string {
+	q := url.Values{}
+	if k.Datacenter != "" {
+		q.Set("dc", k.Datacenter)
+	}
+	if k.Namespace != "" {
+		q.Set("ns", k.Namespace)
+	}
+	if k.Partition != "" {
+		q.Set("partition", k.Partition)
+	}
+	if len(q) == 0 {
+		return name
+	}
+	return name + "?" + q.Encode()
+}
+
+// queryOptions overrides the datacenter, namespace, partition and token of the query with the ones of the key.
+func (k Key) queryOptions(q *api.QueryOptions) *api.QueryOptions {
+	if k.Datacenter != "" {
+		q.Datacenter = k.Datacenter
+	}
+	if k.Namespace != "" {
+		q.Namespace = k.Namespace
+	}
+	if k.Partition != "" {
+		q.Partition = k.Partition
+	}
+	if k.Token != "" {
+		q.Token = k.Token
+	}
+	return q
+}
+
+// writeOptions overrides the datacenter, namespace, partition and token of the write with the ones of the key.
+func (k Key) writeOptions(w *api.WriteOptions) *api.WriteOptions {
+	if k.Datacenter != "" {
+		w.Datacenter = k.Datacenter
+	}
+	if k.Namespace != "" {
+		w.Namespace = k.Namespace
+	}
+	if k.Partition != "" {
+		w.Partition = k.Partition
+	}
+	if k.Token != "" {
+		w.Token = k.Token
+	}
+	return w
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	ConsulDefaultRetryBackoff    = time.Second
+	ConsulDefaultMaxRetryBackoff = time.Minute
+
+	// watchFailedThreshold is the number of the consecutive failures after which the watch is reported as failed.
+	watchFailedThreshold = 5
+)
+
+// ConsistencyMode is the consistency mode of the consul queries.
+type ConsistencyMode string
+
+const (
+	// ConsistencyDefault reads from the leader, the value may be stale in rare cases of leader change.
+	ConsistencyDefault ConsistencyMode = "default"
+	// ConsistencyStale reads from any server, it scales the reads at the cost of lagging values.
+	ConsistencyStale ConsistencyMode = "stale"
+	// ConsistencyConsistent makes the leader verify its leadership before every read.
+	ConsistencyConsistent ConsistencyMode = "consistent"
+)
+
+// WatchState is the health state of the watch of a key or prefix.
+type WatchState int
+
+const (
+	// WatchHealthy means the last query of the watch succeeded.
+	WatchHealthy WatchState = iota
+	// WatchRetrying means the last query failed and the watch is retrying with backoff.
+	WatchRetrying
+	// WatchFailed means the watch keeps failing or is rejected by the ACL, it still retries
+	// in the background but the operators should take a look.
+	WatchFailed
+)
+
+func (s WatchState) String() string {
+	switch s {
+	case WatchHealthy:
+		return "healthy"
+	case WatchRetrying:
+		return "retrying"
+	case WatchFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// HealthStatus is the health of the watch of a key or prefix.
+type HealthStatus struct {
+	State WatchState
+	// LastSuccess is the time of the last successful query, it's zero before the first success.
+	LastSuccess time.Time
+	// LastError is the error of the last failed query, it's nil once the watch recovers.
+	LastError error
+	// Failures is the number of the consecutive failures.
+	Failures int
+	// Index is the consul index the watch is blocking on.
+	Index uint64
+	// Datacenter is the datacenter serving the key, it's empty for the prefixes.
+	Datacenter string
+}
+
+// watchLoop supervises the blocking queries of a key or prefix, it's shared by all the
+// callbacks registered on the key or prefix.
+type watchLoop struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped bool
+	health  HealthStatus
+	// dc is the datacenter serving the key, see serve.
+	dc string
+
+	// wake interrupts the backoff, stopCh is closed when the loop is stopped.
+	wake   chan struct{}
+	stopCh chan struct{}
+}
+
+func newWatchLoop() watchLoop {
+	return watchLoop{
+		wake:   make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// begin creates the context of the next query, it returns false if the loop is stopped.
+func (l *watchLoop) begin() (context.Context, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return nil, false
+	}
+	if l.cancel != nil {
+		// release the context of the previous query.
+		l.cancel()
+	}
+	select {
+	case <-l.wake:
+		// the restart is taken by this query, it shouldn't skip the next backoff.
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	return ctx, true
+}
+
+// restart cancels the running query and the backoff, the loop issues the next query
+// right away with the current options, e.g. a new token.
+func (l *watchLoop) restart() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// isStopped returns true if the loop is stopped.
+func (l *watchLoop) isStopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// stop stops the loop, the running query is canceled.
+func (l *watchLoop) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	if l.cancel != nil {
+		l.cancel()
+	}
+	close(l.stopCh)
+}
+
+// sleep waits for the backoff, it returns false if the loop is stopped.
+func (l *watchLoop) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-l.wake:
+	case <-l.stopCh:
+		return false
+	}
+	return true
+}
+
+func (l *watchLoop) succeed(index uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.health = HealthStatus{State: WatchHealthy, LastSuccess: time.Now(), Index: index}
+}
+
+func (l *watchLoop) fail(err error) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.health.Failures++
+	l.health.LastError = err
+	l.health.State = WatchRetrying
+	if l.health.Failures >= watchFailedThreshold || errors.Is(err, ErrPermissionDenied) {
+		l.health.State = WatchFailed
+	}
+	return l.health.Failures
+}
+
+func (l *watchLoop) healthStatus() HealthStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	health := l.health
+	health.Datacenter = l.dc
+	return health
+}
+
+// serve records the datacenter serving the key and returns the previous one.
+func (l *watchLoop) serve(dc string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := l.dc
+	l.dc = dc
+	return prev
+}
+
+// datacenter returns the datacenter serving the key.
+func (l *watchLoop) datacenter() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dc
+}
+
+// backoff returns the exponential backoff of the failures with jitter, it's between the half
+// and the whole of the backoff so the watches don't retry at the same time.
+func (c *client) backoff(failures int) time.Duration {
+	d := c.maxRetryBackoff
+	if failures < 32 {
+		if b := c.retryBackoff << (failures - 1); b > 0 && b < d {
+			d = b
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// queryFunc runs a blocking query with the options, it returns the result and the index.
+type queryFunc func(q *api.QueryOptions) (interface{}, *api.QueryMeta, error)
+
+// runLoop runs the blocking queries of the key or prefix until the loop is stopped, the handler is
+// called with the result whenever the index or the datacenter serving the key changes. The failed
+// queries are retried with backoff.
+func (c *client) runLoop(l *watchLoop, target string, query queryFunc, handler func(uint64, interface{})) {
+	var index uint64
+	// dc is the datacenter of the index, the indexes of the datacenters aren't comparable.
+	var dc string
+	for {
+		ctx, ok := l.begin()
+		if !ok {
+			return
+		}
+		q := c.queryOptions()
+		q.WaitIndex = index
+		q.WaitTime = c.waitTime
+		result, meta, err := query(q.WithContext(ctx))
+		if l.isStopped() {
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// the query is canceled by restart.
+				continue
+			}
+			err = wrapError(err)
+			c.observer.OnFetch(target, err)
+			failures := l.fail(err)
+			c.observer.OnWatchError(target, failures, err)
+			backoff := c.backoff(failures)
+			klog.Warnf("[consul] watch %s failed %d times, retry in %s: %s", target, failures, backoff, err)
+			if !l.sleep(backoff) {
+				return
+			}
+			continue
+		}
+		newIndex, newDC := meta.LastIndex, l.datacenter()
+		l.succeed(newIndex)
+		c.observer.OnFetch(target, nil)
+		if newIndex == index && newDC == dc {
+			// the blocking query timed out without any change.
+			continue
+		}
+		if newIndex < index && newDC == dc {
+			// the index goes backwards, e.g. the raft snapshot is restored, start over.
+			klog.Infof("[consul] watch %s index goes backwards from %d to %d", target, index, newIndex)
+		}
+		index, dc = newIndex, newDC
+		if index == 0 {
+			index = 1
+		}
+		if !c.acquire() {
+			return
+		}
+		handler(newIndex, result)
+		c.inflight.Done()
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestBackoff(t *testing.T) {
+	c := &client{retryBackoff: 100 * time.Millisecond, maxRetryBackoff: time.Second}
+	for failures, max := range []time.Duration{100, 100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		if failures == 0 || failures > 5 {
+			max = time.Second
+		}
+		if failures == 0 {
+			continue
+		}
+		d := c.backoff(failures)
+		test.Assert(t, d >= max/2 && d <= max, failures, d)
+	}
+	d := c.backoff(100)
+	test.Assert(t, d >= 500*time.Millisecond && d <= time.Second, d)
+}
+
+func TestWatchLoopHealth(t *testing.T) {
+	l := newWatchLoop()
+	for i := 1; i < watchFailedThreshold; i++ {
+		test.Assert(t, l.fail(errors.New("connection refused")) == i)
+		test.Assert(t, l.healthStatus().State == WatchRetrying)
+	}
+	l.fail(errors.New("connection refused"))
+	test.Assert(t, l.healthStatus().State == WatchFailed)
+
+	l.succeed(10)
+	health := l.healthStatus()
+	test.Assert(t, health.State == WatchHealthy && health.Index == 10 && health.Failures == 0)
+
+	// the watch rejected by the ACL fails at once.
+	l.fail(ErrPermissionDenied)
+	test.Assert(t, l.healthStatus().State == WatchFailed)
+
+	l.stop()
+	_, ok := l.begin()
+	test.Assert(t, !ok)
+	test.Assert(t, !l.sleep(time.Minute))
+}
+
+func TestConsistency(t *testing.T) {
+	_, err := NewClient(Options{Consistency: "linearizable"})
+	test.Assert(t, err != nil)
+
+	cli, err := NewClient(Options{Consistency: ConsistencyStale})
+	test.Assert(t, err == nil)
+	q := cli.(*client).queryOptions()
+	test.Assert(t, q.AllowStale && !q.RequireConsistent)
+
+	cli, err = NewClient(Options{Consistency: ConsistencyConsistent})
+	test.Assert(t, err == nil)
+	q = cli.(*client).queryOptions()
+	test.Assert(t, !q.AllowStale && q.RequireConsistent)
+}
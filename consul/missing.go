@@ -0,0 +1,118 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/hashicorp/consul/api"
+)
+
+// MissingKeyPolicy decides what the client does when a watched key doesn't exist in consul.
+type MissingKeyPolicy string
+
+const (
+	// MissingKeyCreate creates the key with the template of its category and config type.
+	MissingKeyCreate MissingKeyPolicy = "create"
+	// MissingKeyIgnore leaves the key missing, the callbacks receive the value once it's created by others.
+	MissingKeyIgnore MissingKeyPolicy = "ignore"
+)
+
+// defaultKeyTemplates are the values of the created keys if no template of the category is set.
+// The empty value is a valid empty document of YAML and HCL.
+var defaultKeyTemplates = map[ConfigType]string{
+	JSON: "{}",
+}
+
+// keyName returns the consul key of the Key, the Path is the whole key if there is no Prefix.
+func keyName(key Key) string {
+	if key.Prefix == "" {
+		return key.Path
+	}
+	return key.Prefix + "/" + key.Path
+}
+
+// DeclareKey records the category and the config type of the key, they select the template
+// written when the key is missing, and the location of the key. The fallbacks of the key are
+// declared as optional keys in the same location. The declaration is identified by Key.ID.
+func (c *client) DeclareKey(key Key) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.declared[key.ID()] = key
+	for _, fallback := range key.Fallbacks {
+		layer := fallbackKey(key, fallback)
+		if _, ok := c.declared[layer.ID()]; !ok {
+			c.declared[layer.ID()] = layer
+		}
+	}
+}
+
+// fallbackKey returns the declaration of the fallback of the key, it's in the location of the key.
+func fallbackKey(key Key, fallback string) Key {
+	return Key{
+		Type:       key.Type,
+		Path:       fallback,
+		Category:   key.Category,
+		Optional:   true,
+		Datacenter: key.Datacenter,
+		Namespace:  key.Namespace,
+		Partition:  key.Partition,
+		Token:      key.Token,
+	}
+}
+
+// declaredKey returns the declaration of the key identified by id, the keys not declared are
+// JSON keys of the client's location named id.
+func (c *client) declaredKey(id string) Key {
+	c.m.Lock()
+	defer c.m.Unlock()
+	declared, ok := c.declared[id]
+	if !ok {
+		declared = Key{Type: JSON, Path: id}
+	}
+	return declared
+}
+
+// keyTemplate returns the value of the missing key, the key of the Auto type is created in
+// the type of its suffix.
+func (c *client) keyTemplate(key string, declared Key) string {
+	if template, ok := c.keyTemplates[declared.Category][declared.Type]; ok {
+		return template
+	}
+	configType := declared.Type
+	if configType == Auto {
+		configType = DetectConfigType(key, 0, "")
+	}
+	return defaultKeyTemplates[configType]
+}
+
+// createMissingKey creates the key with its template unless the client is read-only, the
+// missing keys are ignored or the key is optional.
+func (c *client) createMissingKey(id string) {
+	declared := c.declaredKey(id)
+	key := keyName(declared)
+	if c.readOnly || c.missingKey == MissingKeyIgnore || declared.Optional {
+		klog.Debugf("[consul] key: %s doesn't exist, wait for it to be created", key)
+		return
+	}
+	klog.Debugf("[consul] key: %s doesn't exist, create it", key)
+	// the zero ModifyIndex only creates the key, the value written by others meanwhile is kept.
+	_, _, err := c.consulCli.KV().CAS(&api.KVPair{
+		Key:   key,
+		Value: []byte(c.keyTemplate(key, declared)),
+	}, declared.writeOptions(c.writeOptions()))
+	if err != nil {
+		klog.Errorf("[consul] Add key: %s failed,error: %s", key, wrapError(err).Error())
+	}
+}
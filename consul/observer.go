@@ -0,0 +1,110 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+// Observer receives the lifecycle events of the configs, e.g. to raise alerts, write audit
+// logs or record metrics. The hooks are called synchronously, so they must not block.
+// Embed BaseObserver to implement only some of the hooks.
+type Observer interface {
+	// OnFetch is called when a read or a watch query of the key or prefix returns, err is nil on success.
+	OnFetch(key string, err error)
+	// OnUpdate is called when a new value of the key is received. key is the one the key is
+	// watched by like the other hooks, e.g. with the location of a located key, while event.Key
+	// is the consul name of the key.
+	OnUpdate(key string, event ConfigEvent)
+	// OnDeleted is called when the key is deleted.
+	OnDeleted(key string)
+	// OnWatchError is called when the watch of the key or prefix fails, failures is the
+	// number of the consecutive failures.
+	OnWatchError(key string, failures int, err error)
+	// OnCallbacks is called when a callback of the key or prefix is registered or deregistered,
+	// callbacks is the number of the callbacks registered on it.
+	OnCallbacks(key string, callbacks int)
+	// OnDecodeError is called when the category fails to decode the value of the key.
+	OnDecodeError(key, category string, err error)
+	// OnApplyError is called when the category rejects the decoded value of the key.
+	OnApplyError(key, category string, err error)
+	// OnApplied is called when the category applies the value of the key of the index,
+	// including going back to the defaults after the key is deleted. policies is the number
+	// of the policies in effect, e.g. the methods configured.
+	OnApplied(key, category string, index uint64, policies int)
+}
+
+// BaseObserver implements Observer with no-op hooks.
+type BaseObserver struct{}
+
+var _ Observer = BaseObserver{}
+
+func (BaseObserver) OnFetch(string, error)                 {}
+func (BaseObserver) OnUpdate(string, ConfigEvent)          {}
+func (BaseObserver) OnDeleted(string)                      {}
+func (BaseObserver) OnWatchError(string, int, error)       {}
+func (BaseObserver) OnCallbacks(string, int)               {}
+func (BaseObserver) OnDecodeError(string, string, error)   {}
+func (BaseObserver) OnApplyError(string, string, error)    {}
+func (BaseObserver) OnApplied(string, string, uint64, int) {}
+
+// Observers fans out the events to all the observers in order.
+type Observers []Observer
+
+var _ Observer = Observers{}
+
+func (o Observers) OnFetch(key string, err error) {
+	for _, observer := range o {
+		observer.OnFetch(key, err)
+	}
+}
+
+func (o Observers) OnUpdate(key string, event ConfigEvent) {
+	for _, observer := range o {
+		observer.OnUpdate(key, event)
+	}
+}
+
+func (o Observers) OnDeleted(key string) {
+	for _, observer := range o {
+		observer.OnDeleted(key)
+	}
+}
+
+func (o Observers) OnWatchError(key string, failures int, err error) {
+	for _, observer := range o {
+		observer.OnWatchError(key, failures, err)
+	}
+}
+
+func (o Observers) OnCallbacks(key string, callbacks int) {
+	for _, observer := range o {
+		observer.OnCallbacks(key, callbacks)
+	}
+}
+
+func (o Observers) OnDecodeError(key, category string, err error) {
+	for _, observer := range o {
+		observer.OnDecodeError(key, category, err)
+	}
+}
+
+func (o Observers) OnApplyError(key, category string, err error) {
+	for _, observer := range o {
+		observer.OnApplyError(key, category, err)
+	}
+}
+
+func (o Observers) OnApplied(key, category string, index uint64, policies int) {
+	for _, observer := range o {
+		observer.OnApplied(key, category, index, policies)
+	}
+}
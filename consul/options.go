@@ -0,0 +1,322 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The environment variables read by OptionsFromEnv, the CONSUL_HTTP_* ones are the same as the consul CLI.
+const (
+	EnvHTTPAddr      = "CONSUL_HTTP_ADDR"
+	EnvHTTPToken     = "CONSUL_HTTP_TOKEN"
+	EnvHTTPTokenFile = "CONSUL_HTTP_TOKEN_FILE"
+	EnvHTTPAuth      = "CONSUL_HTTP_AUTH"
+	EnvHTTPSSL       = "CONSUL_HTTP_SSL"
+	EnvHTTPSSLVerify = "CONSUL_HTTP_SSL_VERIFY"
+	EnvCACert        = "CONSUL_CACERT"
+	EnvClientCert    = "CONSUL_CLIENT_CERT"
+	EnvClientKey     = "CONSUL_CLIENT_KEY"
+	EnvTLSServerName = "CONSUL_TLS_SERVER_NAME"
+	EnvNamespace     = "CONSUL_NAMESPACE"
+	EnvPartition     = "CONSUL_PARTITION"
+	EnvDataCenter    = "CONSUL_CONFIG_DATACENTER"
+	// EnvFallbackDataCenters is the comma-separated list of the fallback datacenters.
+	EnvFallbackDataCenters = "CONSUL_CONFIG_FALLBACK_DATACENTERS"
+	EnvPrefix              = "CONSUL_CONFIG_PREFIX"
+	EnvServerPathFormat    = "CONSUL_CONFIG_SERVER_PATH_FORMAT"
+	EnvClientPathFormat    = "CONSUL_CONFIG_CLIENT_PATH_FORMAT"
+	EnvTimeout             = "CONSUL_CONFIG_TIMEOUT"
+	EnvCacheDir            = "CONSUL_CONFIG_CACHE_DIR"
+	EnvWaitTime            = "CONSUL_CONFIG_WAIT_TIME"
+	EnvConsistency         = "CONSUL_CONFIG_CONSISTENCY"
+	EnvRetryBackoff        = "CONSUL_CONFIG_RETRY_BACKOFF"
+	EnvMaxRetryBackoff     = "CONSUL_CONFIG_MAX_RETRY_BACKOFF"
+	EnvMissingKey          = "CONSUL_CONFIG_MISSING_KEY"
+	EnvReadOnly            = "CONSUL_CONFIG_READ_ONLY"
+	EnvConfigType          = "CONSUL_CONFIG_TYPE"
+)
+
+// OptionsFromEnv loads the options from the environment variables, the variables not set
+// are left empty. NewClient calls it to fill the options that are not set explicitly.
+func OptionsFromEnv() (Options, error) {
+	opts := Options{
+		Addr:             os.Getenv(EnvHTTPAddr),
+		Token:            os.Getenv(EnvHTTPToken),
+		TokenFile:        os.Getenv(EnvHTTPTokenFile),
+		NamespaceId:      os.Getenv(EnvNamespace),
+		Partition:        os.Getenv(EnvPartition),
+		DataCenter:       os.Getenv(EnvDataCenter),
+		Prefix:           os.Getenv(EnvPrefix),
+		ServerPathFormat: os.Getenv(EnvServerPathFormat),
+		ClientPathFormat: os.Getenv(EnvClientPathFormat),
+		CacheDir:         os.Getenv(EnvCacheDir),
+		Consistency:      ConsistencyMode(os.Getenv(EnvConsistency)),
+		MissingKey:       MissingKeyPolicy(os.Getenv(EnvMissingKey)),
+		ConfigType:       ConfigType(os.Getenv(EnvConfigType)),
+	}
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{EnvTimeout, &opts.TimeOut},
+		{EnvWaitTime, &opts.WaitTime},
+		{EnvRetryBackoff, &opts.RetryBackoff},
+		{EnvMaxRetryBackoff, &opts.MaxRetryBackoff},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.env)
+		if v == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse %s failed: %w", d.env, err)
+		}
+		*d.dst = duration
+	}
+	if v := os.Getenv(EnvFallbackDataCenters); v != "" {
+		for _, dc := range strings.Split(v, ",") {
+			if dc = strings.TrimSpace(dc); dc != "" {
+				opts.FallbackDataCenters = append(opts.FallbackDataCenters, dc)
+			}
+		}
+	}
+	if v := os.Getenv(EnvReadOnly); v != "" {
+		readOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse %s failed: %w", EnvReadOnly, err)
+		}
+		opts.ReadOnly = readOnly
+	}
+	if v := os.Getenv(EnvHTTPAuth); v != "" {
+		username, password, _ := strings.Cut(v, ":")
+		opts.BasicAuth = &BasicAuth{Username: username, Password: password}
+	}
+
+	tls := TLSConfig{
+		CAFile:     os.Getenv(EnvCACert),
+		CertFile:   os.Getenv(EnvClientCert),
+		KeyFile:    os.Getenv(EnvClientKey),
+		ServerName: os.Getenv(EnvTLSServerName),
+	}
+	useTLS := tls.CAFile != "" || tls.CertFile != "" || tls.KeyFile != "" || tls.ServerName != ""
+	if v := os.Getenv(EnvHTTPSSL); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse %s failed: %w", EnvHTTPSSL, err)
+		}
+		useTLS = useTLS || enabled
+	}
+	if v := os.Getenv(EnvHTTPSSLVerify); v != "" {
+		verify, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse %s failed: %w", EnvHTTPSSLVerify, err)
+		}
+		tls.InsecureSkipVerify = !verify
+	}
+	if useTLS {
+		opts.TLS = &tls
+	}
+	return opts, nil
+}
+
+// fileOptions is the layout of the options file.
+type fileOptions struct {
+	Addr                string    `json:"addr"`
+	Addrs               []string  `json:"addrs"`
+	Prefix              string    `json:"prefix"`
+	ServerPathFormat    string    `json:"server_path_format"`
+	ClientPathFormat    string    `json:"client_path_format"`
+	DataCenter          string    `json:"datacenter"`
+	FallbackDataCenters []string  `json:"fallback_datacenters"`
+	Timeout             string    `json:"timeout"`
+	WaitTime            string    `json:"wait_time"`
+	Consistency         string    `json:"consistency"`
+	RetryBackoff        string    `json:"retry_backoff"`
+	MaxRetryBackoff     string    `json:"max_retry_backoff"`
+	Namespace           string    `json:"namespace"`
+	Token               string    `json:"token"`
+	TokenFile           string    `json:"token_file"`
+	Partition           string    `json:"partition"`
+	CacheDir            string    `json:"cache_dir"`
+	Scheme              string    `json:"scheme"`
+	TLS                 *fileTLS  `json:"tls"`
+	BasicAuth           *fileAuth `json:"basic_auth"`
+	MissingKey          string    `json:"missing_key"`
+	ReadOnly            bool      `json:"read_only"`
+	ConfigType          string    `json:"config_type"`
+	// KeyTemplates is keyed by the category and then the config type.
+	KeyTemplates map[string]map[ConfigType]string `json:"key_templates"`
+}
+
+type fileTLS struct {
+	CAFile             string `json:"ca_file"`
+	CAPem              string `json:"ca_pem"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	CertPEM            string `json:"cert_pem"`
+	KeyPEM             string `json:"key_pem"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+type fileAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// OptionsFromFile loads the options from a YAML or JSON file, e.g.
+//
+//	addr: consul.example.com:8501
+//	datacenter: dc2
+//	timeout: 3s
+//	tls:
+//	  ca_file: /etc/consul/ca.pem
+//
+// The unknown fields are rejected to catch the typos.
+func OptionsFromFile(path string) (Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Options{}, err
+	}
+	fo := &fileOptions{}
+	if err = yaml.UnmarshalStrict(data, fo); err != nil {
+		return Options{}, fmt.Errorf("parse consul options file %s failed: %w", path, err)
+	}
+	opts := Options{
+		Addr:                fo.Addr,
+		Addrs:               fo.Addrs,
+		Prefix:              fo.Prefix,
+		ServerPathFormat:    fo.ServerPathFormat,
+		ClientPathFormat:    fo.ClientPathFormat,
+		DataCenter:          fo.DataCenter,
+		FallbackDataCenters: fo.FallbackDataCenters,
+		NamespaceId:         fo.Namespace,
+		Token:               fo.Token,
+		TokenFile:           fo.TokenFile,
+		Partition:           fo.Partition,
+		CacheDir:            fo.CacheDir,
+		Scheme:              fo.Scheme,
+		Consistency:         ConsistencyMode(fo.Consistency),
+		MissingKey:          MissingKeyPolicy(fo.MissingKey),
+		ReadOnly:            fo.ReadOnly,
+		ConfigType:          ConfigType(fo.ConfigType),
+		KeyTemplates:        fo.KeyTemplates,
+	}
+	durations := []struct {
+		name string
+		src  string
+		dst  *time.Duration
+	}{
+		{"timeout", fo.Timeout, &opts.TimeOut},
+		{"wait_time", fo.WaitTime, &opts.WaitTime},
+		{"retry_backoff", fo.RetryBackoff, &opts.RetryBackoff},
+		{"max_retry_backoff", fo.MaxRetryBackoff, &opts.MaxRetryBackoff},
+	}
+	for _, d := range durations {
+		if d.src == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.src); err != nil {
+			return Options{}, fmt.Errorf("parse %s of consul options file %s failed: %w", d.name, path, err)
+		}
+	}
+	if fo.TLS != nil {
+		opts.TLS = &TLSConfig{
+			CAFile:             fo.TLS.CAFile,
+			CAPem:              []byte(fo.TLS.CAPem),
+			CertFile:           fo.TLS.CertFile,
+			KeyFile:            fo.TLS.KeyFile,
+			CertPEM:            []byte(fo.TLS.CertPEM),
+			KeyPEM:             []byte(fo.TLS.KeyPEM),
+			ServerName:         fo.TLS.ServerName,
+			InsecureSkipVerify: fo.TLS.InsecureSkipVerify,
+		}
+	}
+	if fo.BasicAuth != nil {
+		opts.BasicAuth = &BasicAuth{Username: fo.BasicAuth.Username, Password: fo.BasicAuth.Password}
+	}
+	return opts, nil
+}
+
+// fillEmpty sets the fields of opts that are empty from the fallback.
+func fillEmpty(opts *Options, fallback Options) {
+	setString := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	// an explicit address isn't replaced by the address list, and vice versa.
+	if opts.Addr == "" && len(opts.Addrs) == 0 {
+		opts.Addr, opts.Addrs = fallback.Addr, fallback.Addrs
+	}
+	setString(&opts.Prefix, fallback.Prefix)
+	setString(&opts.ServerPathFormat, fallback.ServerPathFormat)
+	setString(&opts.ClientPathFormat, fallback.ClientPathFormat)
+	setString(&opts.DataCenter, fallback.DataCenter)
+	if len(opts.FallbackDataCenters) == 0 {
+		opts.FallbackDataCenters = fallback.FallbackDataCenters
+	}
+	setString(&opts.NamespaceId, fallback.NamespaceId)
+	// the token and the token file are filled together, so an explicit token isn't replaced by the token file.
+	if opts.Token == "" && opts.TokenFile == "" {
+		opts.Token, opts.TokenFile = fallback.Token, fallback.TokenFile
+	}
+	setString(&opts.Partition, fallback.Partition)
+	setString(&opts.CacheDir, fallback.CacheDir)
+	setString(&opts.Scheme, fallback.Scheme)
+	setDuration := func(dst *time.Duration, src time.Duration) {
+		if *dst == 0 {
+			*dst = src
+		}
+	}
+	setDuration(&opts.TimeOut, fallback.TimeOut)
+	setDuration(&opts.WaitTime, fallback.WaitTime)
+	setDuration(&opts.RetryBackoff, fallback.RetryBackoff)
+	setDuration(&opts.MaxRetryBackoff, fallback.MaxRetryBackoff)
+	if opts.Consistency == "" {
+		opts.Consistency = fallback.Consistency
+	}
+	if opts.MissingKey == "" {
+		opts.MissingKey = fallback.MissingKey
+	}
+	if opts.ConfigType == "" {
+		opts.ConfigType = fallback.ConfigType
+	}
+	if opts.KeyTemplates == nil {
+		opts.KeyTemplates = fallback.KeyTemplates
+	}
+	// the read-only mode can't be turned off by the fallback.
+	opts.ReadOnly = opts.ReadOnly || fallback.ReadOnly
+	if opts.TLS == nil {
+		opts.TLS = fallback.TLS
+	}
+	if opts.BasicAuth == nil {
+		opts.BasicAuth = fallback.BasicAuth
+	}
+	if opts.LoggerConfig == nil {
+		opts.LoggerConfig = fallback.LoggerConfig
+	}
+	if opts.ConfigParser == nil {
+		opts.ConfigParser = fallback.ConfigParser
+	}
+}
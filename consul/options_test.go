@@ -0,0 +1,136 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvHTTPAddr, "consul.example.com:8501")
+	t.Setenv(EnvHTTPToken, "token")
+	t.Setenv(EnvHTTPAuth, "user:pass:word")
+	t.Setenv(EnvHTTPSSLVerify, "false")
+	t.Setenv(EnvCACert, "/etc/consul/ca.pem")
+	t.Setenv(EnvDataCenter, "dc2")
+	t.Setenv(EnvFallbackDataCenters, "dc1, dc3")
+	t.Setenv(EnvPrefix, "Config")
+	t.Setenv(EnvTimeout, "3s")
+	t.Setenv(EnvMissingKey, "ignore")
+	t.Setenv(EnvReadOnly, "true")
+	t.Setenv(EnvConfigType, "auto")
+
+	opts, err := OptionsFromEnv()
+	test.Assert(t, err == nil, err)
+	test.Assert(t, opts.Addr == "consul.example.com:8501")
+	test.Assert(t, opts.Token == "token")
+	test.Assert(t, opts.BasicAuth.Username == "user" && opts.BasicAuth.Password == "pass:word")
+	test.Assert(t, opts.TLS.CAFile == "/etc/consul/ca.pem" && opts.TLS.InsecureSkipVerify)
+	test.Assert(t, opts.DataCenter == "dc2" && opts.Prefix == "Config")
+	test.Assert(t, len(opts.FallbackDataCenters) == 2 && opts.FallbackDataCenters[1] == "dc3", opts.FallbackDataCenters)
+	test.Assert(t, opts.TimeOut == 3*time.Second)
+	test.Assert(t, opts.MissingKey == MissingKeyIgnore && opts.ReadOnly)
+	test.Assert(t, opts.ConfigType == Auto)
+
+	// the explicit values take precedence over the environment variables.
+	explicit := Options{Addr: "127.0.0.1:8500", TLS: &TLSConfig{}}
+	fillEmpty(&explicit, opts)
+	test.Assert(t, explicit.Addr == "127.0.0.1:8500")
+	test.Assert(t, explicit.TLS.CAFile == "")
+	test.Assert(t, explicit.Token == "token")
+
+	t.Setenv(EnvTimeout, "3")
+	_, err = OptionsFromEnv()
+	test.Assert(t, err != nil)
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	t.Setenv(EnvHTTPToken, "env-token")
+
+	// the environment variables are only read by NewClientFromEnv.
+	for _, tc := range []struct {
+		newClient func(Options) (Client, error)
+		opts      Options
+		token     string
+	}{
+		{NewClient, Options{}, ""},
+		{NewClientFromEnv, Options{}, "env-token"},
+		{NewClientFromEnv, Options{Token: "token"}, "token"},
+	} {
+		cli, err := tc.newClient(tc.opts)
+		test.Assert(t, err == nil, err)
+		test.Assert(t, cli.(*client).currentToken() == tc.token, cli.(*client).currentToken())
+		cli.Close(context.Background())
+	}
+
+	// a malformed variable fails NewClientFromEnv only.
+	t.Setenv(EnvTimeout, "3")
+	_, err := NewClientFromEnv(Options{})
+	test.Assert(t, err != nil)
+	cli, err := NewClient(Options{})
+	test.Assert(t, err == nil, err)
+	cli.Close(context.Background())
+}
+
+func TestOptionsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "consul.yaml")
+	err := os.WriteFile(yamlFile, []byte(`
+addr: consul.example.com:8501
+datacenter: dc2
+fallback_datacenters: [dc1]
+server_path_format: "{{.ServerServiceName}}/server/{{.Category}}"
+timeout: 3s
+wait_time: 1m
+consistency: stale
+tls:
+  ca_file: /etc/consul/ca.pem
+basic_auth:
+  username: user
+key_templates:
+  retry:
+    yaml: "{}"
+`), 0o644)
+	test.Assert(t, err == nil)
+	opts, err := OptionsFromFile(yamlFile)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, opts.Addr == "consul.example.com:8501" && opts.DataCenter == "dc2")
+	test.Assert(t, len(opts.FallbackDataCenters) == 1 && opts.FallbackDataCenters[0] == "dc1")
+	test.Assert(t, opts.ServerPathFormat == "{{.ServerServiceName}}/server/{{.Category}}")
+	test.Assert(t, opts.TimeOut == 3*time.Second)
+	test.Assert(t, opts.WaitTime == time.Minute && opts.Consistency == ConsistencyStale)
+	test.Assert(t, opts.TLS.CAFile == "/etc/consul/ca.pem")
+	test.Assert(t, opts.BasicAuth.Username == "user")
+	test.Assert(t, opts.KeyTemplates["retry"][YAML] == "{}")
+
+	jsonFile := filepath.Join(dir, "consul.json")
+	err = os.WriteFile(jsonFile, []byte(`{"addr":"consul.example.com:8501","namespace":"ns"}`), 0o644)
+	test.Assert(t, err == nil)
+	opts, err = OptionsFromFile(jsonFile)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, opts.Addr == "consul.example.com:8501" && opts.NamespaceId == "ns")
+	test.Assert(t, opts.TLS == nil)
+
+	err = os.WriteFile(jsonFile, []byte(`{"address":"consul.example.com:8501"}`), 0o644)
+	test.Assert(t, err == nil)
+	_, err = OptionsFromFile(jsonFile)
+	test.Assert(t, err != nil)
+}
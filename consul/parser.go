@@ -17,6 +17,7 @@ package consul
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	yaml "sigs.k8s.io/yaml/goyaml.v3"
@@ -45,24 +46,78 @@ type ConfigParamConfig struct {
 	Category          string
 	ClientServiceName string
 	ServerServiceName string
+	// Env, Cluster, Region and Zone are the deployment dimensions of the service, e.g. to
+	// render "{{.Env}}/{{.ServerServiceName}}/{{.Category}}".
+	Env     string
+	Cluster string
+	Region  string
+	Zone    string
+	// Method is the method of the method-level keys, it's empty for the service-level keys.
+	Method string
+	// Tags are the free-form dimensions, e.g. rendered by {{index .Tags "team"}}.
+	Tags map[string]string
 }
 
 type ConfigParser interface {
 	Decode(configType ConfigType, data string, config interface{}) error
 }
+
+// DecoderFunc decodes the config data of a ConfigType into config.
+type DecoderFunc func(data string, config interface{}) error
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[ConfigType]DecoderFunc{
+		JSON: func(data string, config interface{}) error {
+			return json.Unmarshal([]byte(data), config)
+		},
+		YAML: func(data string, config interface{}) error {
+			return yaml.Unmarshal([]byte(data), config)
+		},
+		HCL: decodeHCL,
+	}
+)
+
+// RegisterDecoder registers the decoder of the config type to the default parser, so new
+// formats can be added without re-implementing the builtin ones. Registering a builtin type
+// replaces the builtin decoder, and a nil decoder removes the type.
+func RegisterDecoder(configType ConfigType, decoder DecoderFunc) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if decoder == nil {
+		delete(decoders, configType)
+		return
+	}
+	decoders[configType] = decoder
+}
+
+func lookupDecoder(configType ConfigType) (DecoderFunc, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	decoder, ok := decoders[configType]
+	return decoder, ok
+}
+
 type parser struct{}
 
+// Decode decodes the data with the decoder registered for the config type, the type of
+// the Auto data is sniffed from the data.
 func (p *parser) Decode(configType ConfigType, data string, config interface{}) error {
-	switch configType {
-	case JSON:
-		return json.Unmarshal([]byte(data), config)
-	case YAML:
-		return yaml.Unmarshal([]byte(data), config)
-	default:
+	if configType == Auto {
+		configType = DetectConfigType("", 0, data)
+	}
+	decoder, ok := lookupDecoder(configType)
+	if !ok {
 		return fmt.Errorf("unsupported config data type %s", configType)
 	}
+	return decoder(data, config)
 }
 
 func defaultConfigParse() ConfigParser {
 	return &parser{}
 }
+
+// DefaultConfigParser returns the parser used when Options.ConfigParser is not set.
+func DefaultConfigParser() ConfigParser {
+	return defaultConfigParse()
+}
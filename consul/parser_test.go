@@ -0,0 +1,126 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/circuitbreak"
+	"github.com/cloudwego/kitex/pkg/limiter"
+	"github.com/cloudwego/kitex/pkg/retry"
+	"github.com/cloudwego/kitex/pkg/rpctimeout"
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/pkg/degradation"
+)
+
+func TestDecodeHCL(t *testing.T) {
+	p := defaultConfigParse()
+	data := `
+"*" {
+  enable = true
+  type   = 0
+  failure_policy {
+    stop_policy {
+      max_retry_times = 3
+      error_rate      = 0.3
+      cb_policy {
+        error_rate = 0.3
+      }
+    }
+    retry_same_node = true
+  }
+}
+
+echo {
+  enable = false
+  type   = 1
+  backup_policy {
+    retry_delay_ms = 100
+  }
+}
+`
+	policies := map[string]*retry.Policy{}
+	test.Assert(t, p.Decode(HCL, data, &policies) == nil)
+	test.Assert(t, len(policies) == 2)
+	test.Assert(t, policies["*"].Enable)
+	test.Assert(t, policies["*"].FailurePolicy.StopPolicy.MaxRetryTimes == 3)
+	test.Assert(t, policies["*"].FailurePolicy.StopPolicy.CBPolicy.ErrorRate == 0.3)
+	test.Assert(t, policies["*"].FailurePolicy.RetrySameNode)
+	test.Assert(t, !policies["echo"].Enable && policies["echo"].Type == retry.BackupType)
+	test.Assert(t, policies["echo"].FailurePolicy == nil && policies["echo"].BackupPolicy.RetryDelayMS == 100)
+
+	breakers := map[string]circuitbreak.CBConfig{}
+	test.Assert(t, p.Decode(HCL, "echo {\n  enable = true\n  err_rate = 0.5\n  min_sample = 100\n}", &breakers) == nil)
+	test.Assert(t, breakers["echo"] == circuitbreak.CBConfig{Enable: true, ErrRate: 0.5, MinSample: 100}, breakers)
+
+	timeouts := map[string]*rpctimeout.RPCTimeout{}
+	test.Assert(t, p.Decode(HCL, "\"*\" {\n  rpc_timeout_ms = 100\n  conn_timeout_ms = 50\n}", &timeouts) == nil)
+	test.Assert(t, *timeouts["*"] == rpctimeout.RPCTimeout{RPCTimeoutMS: 100, ConnTimeoutMS: 50}, timeouts)
+
+	var dc degradation.DegradationConfig
+	test.Assert(t, p.Decode(HCL, "enable = true\npercentage = 30", &dc) == nil)
+	test.Assert(t, dc == degradation.DegradationConfig{Enable: true, Percentage: 30}, dc)
+
+	var limit limiter.LimiterConfig
+	test.Assert(t, p.Decode(HCL, "connection_limit = 100\nqps_limit = 2000", &limit) == nil)
+	test.Assert(t, limit.ConnectionLimit == 100 && limit.QPSLimit == 2000)
+
+	err := p.Decode(HCL, "enable = true\necho {\n  enable = \n}", &policies)
+	test.Assert(t, err != nil)
+	test.Assert(t, strings.HasPrefix(err.Error(), "hcl: line 4, column 2"), err)
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	const csv ConfigType = "csv"
+	p := defaultConfigParse()
+	var fields []string
+	test.Assert(t, p.Decode(csv, "a,b", &fields) != nil)
+
+	RegisterDecoder(csv, func(data string, config interface{}) error {
+		*config.(*[]string) = strings.Split(data, ",")
+		return nil
+	})
+	test.Assert(t, p.Decode(csv, "a,b", &fields) == nil)
+	test.Assert(t, len(fields) == 2 && fields[1] == "b")
+
+	RegisterDecoder(csv, nil)
+	test.Assert(t, p.Decode(csv, "a,b", &fields) != nil)
+
+	var m map[string]int
+	test.Assert(t, p.Decode(YAML, "a: 1", &m) == nil && m["a"] == 1)
+}
+
+func TestDetectConfigType(t *testing.T) {
+	test.Assert(t, DetectConfigType("retry", 0, `{"*":{"enable":true}}`) == JSON)
+	test.Assert(t, DetectConfigType("retry", 0, "'*':\n  enable: true\n") == YAML)
+	test.Assert(t, DetectConfigType("retry", 0, "\"*\" {\n  enable = true\n}\n") == HCL)
+	test.Assert(t, DetectConfigType("limit", 0, "connection_limit = 100\nqps_limit = 2000") == HCL)
+	test.Assert(t, DetectConfigType("limit", 0, "connection_limit: 100\nqps_limit: 2000") == YAML)
+	test.Assert(t, DetectConfigType("limit", 0, "") == YAML)
+	// the suffix of the key takes precedence over the sniffing, and the flags over the suffix.
+	test.Assert(t, DetectConfigType("retry.yml", 0, `{"*":{"enable":true}}`) == YAML)
+	test.Assert(t, DetectConfigType("retry.JSON", 0, "") == JSON)
+	test.Assert(t, DetectConfigType("retry.yaml", FlagsHCL, "") == HCL)
+	test.Assert(t, DetectConfigType("retry.hcl", 42, "") == HCL)
+
+	p := EventParser(defaultConfigParse(), ConfigEvent{Key: "limit", Flags: FlagsYAML})
+	var m map[string]int
+	test.Assert(t, p.Decode(Auto, "{a: 1}", &m) == nil && m["a"] == 1)
+	test.Assert(t, p.Decode(JSON, "{a: 1}", &m) != nil)
+	m = nil
+	test.Assert(t, defaultConfigParse().Decode(Auto, "a = 1", &m) == nil && m["a"] == 1)
+}
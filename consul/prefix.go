@@ -0,0 +1,277 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/hashicorp/consul/api"
+)
+
+// PrefixDiff describes which keys of a watched prefix are changed by an update.
+// The keys are relative to the watched prefix.
+type PrefixDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Empty returns true if nothing is changed.
+func (d PrefixDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// DiffValues compares the subtree before and after an update, the keys in the diff are sorted.
+func DiffValues(old, cur map[string]string) PrefixDiff {
+	var diff PrefixDiff
+	for k, v := range cur {
+		ov, ok := old[k]
+		if !ok {
+			diff.Added = append(diff.Added, k)
+		} else if ov != v {
+			diff.Changed = append(diff.Changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// listPrefix returns the prefix listed from consul, it's bounded at "/" so the prefix
+// "a/retry" doesn't match the key "a/retryX".
+func listPrefix(prefix string) string {
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// subtree converts the pairs under the prefix to a map keyed by the path relative to the prefix.
+// Folder entries and the keys out of the prefix are skipped.
+func subtree(prefix string, pairs api.KVPairs) map[string]string {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if pair == nil || strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		k, ok := strings.CutPrefix(pair.Key, listPrefix(prefix))
+		if !ok {
+			continue
+		}
+		values[k] = string(pair.Value)
+	}
+	return values
+}
+
+// prefixSubscriber is a callback registered on a prefix, it remembers the last subtree it
+// received so it gets the diffs of its own and the subtrees in order.
+type prefixSubscriber struct {
+	callback func(map[string]string, PrefixDiff, ConfigParser)
+	values   map[string]string
+	index    uint64
+	loaded   bool
+}
+
+// prefixWatcher holds the single consul watch of a prefix and fans out every
+// update of the subtree to all the callbacks registered on the prefix.
+type prefixWatcher struct {
+	watchLoop
+	prefix string
+
+	mu          sync.Mutex
+	subscribers map[int64]*prefixSubscriber
+	values      map[string]string
+	index       uint64
+	loaded      bool
+
+	// dispatchMu serializes the deliveries of the prefix, so the callbacks receive the subtrees one by one.
+	dispatchMu sync.Mutex
+}
+
+func newPrefixWatcher(prefix string) *prefixWatcher {
+	return &prefixWatcher{
+		watchLoop:   newWatchLoop(),
+		prefix:      prefix,
+		subscribers: make(map[int64]*prefixSubscriber),
+	}
+}
+
+// add registers the callback of the uniqueID and returns the number of the callbacks.
+func (w *prefixWatcher) add(uniqueID int64, callback func(map[string]string, PrefixDiff, ConfigParser)) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers[uniqueID] = &prefixSubscriber{callback: callback}
+	return len(w.subscribers)
+}
+
+// remove deregisters the callback of the uniqueID and returns the number of the remaining callbacks.
+func (w *prefixWatcher) remove(uniqueID int64) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subscribers, uniqueID)
+	return len(w.subscribers)
+}
+
+// load returns a copy of the latest subtree received by the watch and its index.
+func (w *prefixWatcher) load() (map[string]string, uint64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return CopyValues(w.values), w.index, w.loaded
+}
+
+// notify stores the subtree of the index and delivers it to all the registered callbacks.
+// The subtree is dropped if it's older than the latest one.
+func (w *prefixWatcher) notify(values map[string]string, index uint64, parser ConfigParser) {
+	w.dispatchMu.Lock()
+	defer w.dispatchMu.Unlock()
+
+	w.mu.Lock()
+	if w.loaded && index != 0 && index < w.index {
+		w.mu.Unlock()
+		return
+	}
+	w.values, w.index, w.loaded = values, index, true
+	subscribers := make([]*prefixSubscriber, 0, len(w.subscribers))
+	for _, sub := range w.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	w.mu.Unlock()
+
+	for _, sub := range subscribers {
+		w.deliver(sub, values, index, parser)
+	}
+}
+
+// notifyOne delivers the subtree to the callback of the uniqueID only, it's used for the initial subtree.
+func (w *prefixWatcher) notifyOne(uniqueID int64, values map[string]string, index uint64, parser ConfigParser) {
+	w.dispatchMu.Lock()
+	defer w.dispatchMu.Unlock()
+
+	w.mu.Lock()
+	sub, ok := w.subscribers[uniqueID]
+	w.mu.Unlock()
+	if ok {
+		w.deliver(sub, values, index, parser)
+	}
+}
+
+// deliver calls the callback of the subscriber with the diff from the subtree it received last,
+// unless nothing is changed or it has received a newer subtree. It must be called with dispatchMu held.
+func (w *prefixWatcher) deliver(sub *prefixSubscriber, values map[string]string, index uint64, parser ConfigParser) {
+	if sub.loaded && index != 0 && index < sub.index {
+		return
+	}
+	diff := DiffValues(sub.values, values)
+	if sub.loaded && diff.Empty() {
+		return
+	}
+	sub.values, sub.index, sub.loaded = values, index, true
+	sub.callback(CopyValues(values), diff, parser)
+}
+
+// CopyValues returns a copy of the subtree, so the callbacks can't modify the shared one.
+func CopyValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
+
+// RegisterPrefixCallback register the callback function of a key prefix to consul client.
+// The callback receives the whole subtree under the prefix, keyed by the path relative to
+// the prefix, on every change together with the keys that are added, changed or removed.
+// An error is returned if the initial subtree can't be read from consul, the callback stays
+// registered and receives the subtree once the watch recovers.
+func (c *client) RegisterPrefixCallback(prefix string, uniqueID int64, callback func(map[string]string, PrefixDiff, ConfigParser)) error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return ErrClientClosed
+	}
+	c.inflight.Add(1)
+	defer c.inflight.Done()
+	w, ok := c.prefixWatchers[prefix]
+	if !ok {
+		w = newPrefixWatcher(prefix)
+		c.prefixWatchers[prefix] = w
+	}
+	callbacks := w.add(uniqueID, callback)
+	c.m.Unlock()
+	// the observers are called without the lock, so they can use the client.
+	c.observer.OnCallbacks(prefix, callbacks)
+	if !ok {
+		go c.watchPrefix(w)
+	}
+
+	// the prefix is already watched, reuse the latest subtree instead of querying consul again.
+	if values, index, loaded := w.load(); loaded {
+		w.notifyOne(uniqueID, values, index, c.parser)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.consulTimeout)
+	defer cancel()
+	pairs, meta, err := c.consulCli.KV().List(listPrefix(prefix), c.queryOptions().WithContext(ctx))
+	if err != nil {
+		err = wrapError(err)
+		c.observer.OnFetch(prefix, err)
+		klog.Debugf("[consul] prefix: %s config list values failed", prefix)
+		return fmt.Errorf("list prefix %s from consul failed: %w", prefix, err)
+	}
+	c.observer.OnFetch(prefix, nil)
+	w.notifyOne(uniqueID, subtree(prefix, pairs), meta.LastIndex, c.parser)
+	return nil
+}
+
+// watchPrefix runs the watch loop of the prefix watcher, it returns when the loop is stopped.
+func (c *client) watchPrefix(w *prefixWatcher) {
+	prefix := w.prefix
+	kv := c.consulCli.KV()
+	query := func(q *api.QueryOptions) (interface{}, *api.QueryMeta, error) {
+		return kv.List(listPrefix(prefix), q)
+	}
+	c.runLoop(&w.watchLoop, prefix, query, func(u uint64, i interface{}) {
+		pairs, _ := i.(api.KVPairs)
+		klog.Debugf("[consul] config prefix: %s updated", prefix)
+		w.notify(subtree(prefix, pairs), u, c.parser)
+	})
+}
+
+// DeregisterPrefix deregister the prefix callback of the uniqueID, the consul watch of
+// the prefix is stopped when the last callback is deregistered. It's a no-op if the
+// callback isn't registered.
+func (c *client) DeregisterPrefix(prefix string, uniqueID int64) {
+	c.m.Lock()
+	w, ok := c.prefixWatchers[prefix]
+	if !ok {
+		c.m.Unlock()
+		return
+	}
+	remaining := w.remove(uniqueID)
+	if remaining == 0 {
+		delete(c.prefixWatchers, prefix)
+		w.stop()
+	}
+	c.m.Unlock()
+	c.observer.OnCallbacks(prefix, remaining)
+}
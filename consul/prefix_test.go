@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestPrefixWatcherDiff(t *testing.T) {
+	prefix := "KitexConfig/ClientName/ServiceName/retry"
+	w := newPrefixWatcher(prefix)
+	var diffs []PrefixDiff
+	w.add(1, func(values map[string]string, diff PrefixDiff, parser ConfigParser) {
+		diffs = append(diffs, diff)
+	})
+
+	w.notify(subtree(prefix, api.KVPairs{
+		{Key: prefix + "/"},
+		{Key: prefix + "/Echo", Value: []byte(`{"enable":true}`)},
+		{Key: prefix + "/Ping", Value: []byte(`{"enable":true}`)},
+		// the keys sharing the prefix out of the folder are skipped.
+		{Key: prefix + "X/Other", Value: []byte(`{}`)},
+	}), 10, defaultConfigParse())
+	values, index, loaded := w.load()
+	test.Assert(t, loaded && index == 10)
+	test.Assert(t, reflect.DeepEqual(values, map[string]string{"Echo": `{"enable":true}`, "Ping": `{"enable":true}`}))
+
+	// nothing changed, the callback is not called.
+	w.notify(values, 11, defaultConfigParse())
+	test.Assert(t, len(diffs) == 1)
+
+	w.notify(map[string]string{"Echo": `{"enable":false}`, "Stream": `{}`}, 12, defaultConfigParse())
+	test.Assert(t, len(diffs) == 2)
+	// the older subtree, e.g. the initial one read before the update, is dropped.
+	w.notifyOne(1, values, 10, defaultConfigParse())
+	test.Assert(t, len(diffs) == 2)
+	test.Assert(t, reflect.DeepEqual(diffs[0].Added, []string{"Echo", "Ping"}))
+	test.Assert(t, reflect.DeepEqual(diffs[1], PrefixDiff{
+		Added:   []string{"Stream"},
+		Changed: []string{"Echo"},
+		Removed: []string{"Ping"},
+	}))
+}
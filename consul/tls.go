@@ -0,0 +1,88 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+const schemeHTTPS = "https"
+
+// TLSConfig configures the TLS connection to the consul agent. The CA and the client
+// certificate can be given either as files or as PEM-encoded bytes, the client certificate
+// is only needed when the agent verifies the incoming connections (mTLS).
+type TLSConfig struct {
+	// CAFile is the path to the CA certificate, the system bundle is used if both CAFile and CAPem are empty.
+	CAFile string
+	CAPem  []byte
+	// CertFile and KeyFile are the paths to the client certificate and its private key.
+	CertFile string
+	KeyFile  string
+	// CertPEM and KeyPEM are the PEM-encoded client certificate and its private key.
+	CertPEM []byte
+	KeyPEM  []byte
+	// ServerName is used to verify the hostname of the agent, it defaults to the host of Options.Addr.
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// BasicAuth is the HTTP basic auth credential sent to the consul agent.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// apiConfig builds the config of the consul api client from the options.
+func apiConfig(opts Options) (api.Config, error) {
+	conf := api.Config{
+		Address:    opts.Addr,
+		Scheme:     opts.Scheme,
+		Datacenter: opts.DataCenter,
+		Token:      opts.Token,
+		Namespace:  opts.NamespaceId,
+		Partition:  opts.Partition,
+	}
+	if opts.TLS != nil {
+		if conf.Scheme == "" {
+			conf.Scheme = schemeHTTPS
+		}
+		conf.TLSConfig = api.TLSConfig{
+			Address:            opts.TLS.ServerName,
+			CAFile:             opts.TLS.CAFile,
+			CAPem:              opts.TLS.CAPem,
+			CertFile:           opts.TLS.CertFile,
+			KeyFile:            opts.TLS.KeyFile,
+			CertPEM:            opts.TLS.CertPEM,
+			KeyPEM:             opts.TLS.KeyPEM,
+			InsecureSkipVerify: opts.TLS.InsecureSkipVerify,
+		}
+	}
+	if opts.BasicAuth != nil {
+		conf.HttpAuth = &api.HttpBasicAuth{
+			Username: opts.BasicAuth.Username,
+			Password: opts.BasicAuth.Password,
+		}
+	}
+	if len(opts.Addrs) > 1 {
+		// the KV reads and the watches share the http client, so they fail over together.
+		httpClient, err := api.NewHttpClient(api.DefaultConfig().Transport, conf.TLSConfig)
+		if err != nil {
+			return conf, err
+		}
+		httpClient.Transport = newFailoverTransport(httpClient.Transport, opts.Addrs)
+		conf.HttpClient = httpClient
+	}
+	return conf, nil
+}
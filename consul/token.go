@@ -0,0 +1,121 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrPermissionDenied is returned when consul rejects the ACL token, the token may be expired,
+// revoked or lack the permission of the key.
+var ErrPermissionDenied = errors.New("consul permission denied")
+
+// tokenFileInterval is how often the token file is checked for a new token.
+var tokenFileInterval = 5 * time.Second
+
+// tokenFileRead is called with the token each time the token file is read, the tests use it
+// to wait for the reloads.
+var tokenFileRead = func(string) {}
+
+// wrapError marks the permission denied responses of consul with ErrPermissionDenied.
+func wrapError(err error) error {
+	var se api.StatusError
+	if errors.As(err, &se) && se.Code == http.StatusForbidden {
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, err)
+	}
+	return err
+}
+
+// readTokenFile reads the token from the file, the surrounding whitespaces are trimmed.
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *client) currentToken() string {
+	token, _ := c.token.Load().(string)
+	return token
+}
+
+func (c *client) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{
+		Token:             c.currentToken(),
+		AllowStale:        c.consistency == ConsistencyStale,
+		RequireConsistent: c.consistency == ConsistencyConsistent,
+	}
+}
+
+func (c *client) writeOptions() *api.WriteOptions {
+	return &api.WriteOptions{Token: c.currentToken()}
+}
+
+// SetToken replaces the ACL token, it's used by the following KV reads and the running
+// consul watches are restarted with the new token.
+func (c *client) SetToken(token string) {
+	if c.currentToken() == token {
+		return
+	}
+	c.token.Store(token)
+	klog.Infof("[consul] ACL token is updated, restart the watches")
+
+	c.m.Lock()
+	loops := make([]*watchLoop, 0, len(c.watchers)+len(c.prefixWatchers))
+	for _, w := range c.watchers {
+		loops = append(loops, &w.watchLoop)
+	}
+	for _, w := range c.prefixWatchers {
+		loops = append(loops, &w.watchLoop)
+	}
+	c.m.Unlock()
+	for _, l := range loops {
+		l.restart()
+	}
+}
+
+// watchTokenFile reloads the token file periodically until the client is closed.
+func (c *client) watchTokenFile(path string) {
+	read := tokenFileRead
+	ticker := time.NewTicker(tokenFileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		token, err := readTokenFile(path)
+		if err != nil {
+			klog.Warnf("[consul] read token file %s failed: %s", path, err)
+			continue
+		}
+		if token != "" {
+			c.SetToken(token)
+		} else {
+			klog.Warnf("[consul] token file %s is empty, keep the current token", path)
+		}
+		read(token)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestTokenFile(t *testing.T) {
+	interval, read := tokenFileInterval, tokenFileRead
+	tokens := make(chan string, 10)
+	tokenFileInterval = 10 * time.Millisecond
+	tokenFileRead = func(token string) {
+		select {
+		case tokens <- token:
+		default:
+		}
+	}
+	defer func() { tokenFileInterval, tokenFileRead = interval, read }()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	_, err := NewClient(Options{TokenFile: tokenFile})
+	test.Assert(t, err != nil)
+
+	test.Assert(t, os.WriteFile(tokenFile, []byte(" t1\n"), 0o600) == nil)
+	cli, err := NewClient(Options{Token: "ignored", TokenFile: tokenFile})
+	test.Assert(t, err == nil)
+	defer cli.Close(context.Background())
+	c := cli.(*client)
+	test.Assert(t, c.currentToken() == "t1", c.currentToken())
+
+	test.Assert(t, os.WriteFile(tokenFile, []byte("t2"), 0o600) == nil)
+	waitTokenRead(t, tokens, "t2")
+	test.Assert(t, c.currentToken() == "t2", c.currentToken())
+
+	// an empty token file keeps the current token.
+	test.Assert(t, os.WriteFile(tokenFile, nil, 0o600) == nil)
+	waitTokenRead(t, tokens, "")
+	test.Assert(t, c.currentToken() == "t2", c.currentToken())
+}
+
+// waitTokenRead waits until the token is read from the token file.
+func waitTokenRead(t *testing.T, tokens chan string, token string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case read := <-tokens:
+			if read == token {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for the token %q to be read", token)
+		}
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	err := wrapError(api.StatusError{Code: 403, Body: "ACL not found"})
+	test.Assert(t, errors.Is(err, ErrPermissionDenied), err)
+	err = wrapError(api.StatusError{Code: 500, Body: "No cluster leader"})
+	test.Assert(t, !errors.Is(err, ErrPermissionDenied), err)
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// WatchOption customizes Watch.
+type WatchOption[T any] func(*watchOptions[T])
+
+type watchOptions[T any] struct {
+	configType ConfigType
+	defaults   func(*T)
+	validate   func(T) error
+}
+
+// WithConfigType sets the config type of the key, JSON is used by default.
+func WithConfigType[T any](configType ConfigType) WatchOption[T] {
+	return func(o *watchOptions[T]) {
+		o.configType = configType
+	}
+}
+
+// WithDefaults sets the defaults hook, it fills a new value before the data of the key is
+// decoded into it, so the fields missing in the data keep the defaults. The value is the
+// defaults as well when the key doesn't exist or is deleted.
+func WithDefaults[T any](defaults func(*T)) WatchOption[T] {
+	return func(o *watchOptions[T]) {
+		o.defaults = defaults
+	}
+}
+
+// WithValidator sets the validation hook, the decoded value is rejected if it returns an error.
+func WithValidator[T any](validate func(T) error) WatchOption[T] {
+	return func(o *watchOptions[T]) {
+		o.validate = validate
+	}
+}
+
+// Value is a config of the type T watched from consul. It keeps the last good value, the
+// values failing to decode or validate are dropped.
+type Value[T any] struct {
+	cli      Client
+	key      string
+	uniqueID int64
+	opts     watchOptions[T]
+
+	value atomic.Pointer[T]
+	err   atomic.Pointer[error]
+
+	mu          sync.Mutex
+	subscribers map[int64]func(old, new T)
+	nextID      int64
+}
+
+// Watch watches the key and decodes its value to T with the parser of the client. An error
+// is returned if the key can't be read from consul, the invalid values of the key are only
+// reported by Err and the defaults are used until a valid value is received.
+func Watch[T any](cli Client, key string, opts ...WatchOption[T]) (*Value[T], error) {
+	v := &Value[T]{
+		cli:         cli,
+		key:         key,
+		uniqueID:    AllocateUniqueID(),
+		opts:        watchOptions[T]{configType: JSON},
+		subscribers: make(map[int64]func(old, new T)),
+	}
+	for _, opt := range opts {
+		opt(&v.opts)
+	}
+	initial := v.newValue()
+	v.value.Store(&initial)
+	if err := cli.RegisterConfigEventCallback(key, v.uniqueID, v.update); err != nil {
+		cli.DeregisterConfig(key, v.uniqueID)
+		return nil, err
+	}
+	return v, nil
+}
+
+// newValue returns a new value filled by the defaults hook.
+func (v *Value[T]) newValue() T {
+	var value T
+	if v.opts.defaults != nil {
+		v.opts.defaults(&value)
+	}
+	return value
+}
+
+func (v *Value[T]) update(event ConfigEvent, parser ConfigParser) {
+	value := v.newValue()
+	if !event.Deleted {
+		if err := v.decode(event.Value, parser, &value); err != nil {
+			klog.Warnf("[consul] %s value: %s, keep the last value", v.key, err)
+			v.err.Store(&err)
+			return
+		}
+	}
+	v.err.Store(nil)
+	old := v.value.Swap(&value)
+
+	v.mu.Lock()
+	subscribers := make([]func(old, new T), 0, len(v.subscribers))
+	for _, subscriber := range v.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	v.mu.Unlock()
+	for _, subscriber := range subscribers {
+		subscriber(*old, value)
+	}
+}
+
+func (v *Value[T]) decode(data string, parser ConfigParser, value *T) error {
+	if err := parser.Decode(v.opts.configType, data, value); err != nil {
+		return fmt.Errorf("decode failed: %w", err)
+	}
+	if v.opts.validate != nil {
+		if err := v.opts.validate(*value); err != nil {
+			return fmt.Errorf("validate failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Load returns the current value, the value must not be modified as it's shared by the callers.
+func (v *Value[T]) Load() T {
+	return *v.value.Load()
+}
+
+// Err returns the error of the last value of the key that failed to decode or validate,
+// it's nil once a valid value is received.
+func (v *Value[T]) Err() error {
+	if err := v.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Subscribe registers the callback called with the old and the new value on every change,
+// the returned function cancels the subscription.
+func (v *Value[T]) Subscribe(callback func(old, new T)) (cancel func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	id := v.nextID
+	v.nextID++
+	v.subscribers[id] = callback
+	return func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		delete(v.subscribers, id)
+	}
+}
+
+// Close stops watching the key, the value isn't changed anymore.
+func (v *Value[T]) Close() {
+	v.cli.DeregisterConfig(v.key, v.uniqueID)
+}
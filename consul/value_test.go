@@ -0,0 +1,78 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+)
+
+type businessConfig struct {
+	Name    string `json:"name"`
+	Workers int    `json:"workers"`
+}
+
+func TestWatch(t *testing.T) {
+	cli, err := consultest.NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+	cli.Set(testKey, `{"name":"a"}`)
+
+	v, err := consul.Watch(cli, testKey,
+		consul.WithDefaults(func(c *businessConfig) { c.Workers = 4 }),
+		consul.WithValidator(func(c businessConfig) error {
+			if c.Workers <= 0 {
+				return errors.New("workers must be positive")
+			}
+			return nil
+		}))
+	test.Assert(t, err == nil, err)
+	defer v.Close()
+	test.Assert(t, v.Load() == businessConfig{Name: "a", Workers: 4}, v.Load())
+
+	changes := make(chan [2]businessConfig, 10)
+	cancel := v.Subscribe(func(old, new businessConfig) {
+		changes <- [2]businessConfig{old, new}
+	})
+	cli.Set(testKey, `{"name":"b","workers":8}`)
+	cli.Settle()
+	test.Assert(t, <-changes == [2]businessConfig{{Name: "a", Workers: 4}, {Name: "b", Workers: 8}})
+
+	// the invalid values are dropped, the last good value is kept.
+	cli.Set(testKey, `{"workers":0}`)
+	cli.Set(testKey, `{`)
+	cli.Settle()
+	test.Assert(t, v.Load() == businessConfig{Name: "b", Workers: 8}, v.Load())
+	test.Assert(t, v.Err() != nil)
+	test.Assert(t, len(changes) == 0)
+
+	// the deleted key goes back to the defaults.
+	cli.Delete(testKey)
+	cli.Settle()
+	test.Assert(t, v.Err() == nil)
+	test.Assert(t, v.Load() == businessConfig{Workers: 4}, v.Load())
+	test.Assert(t, len(changes) == 1)
+
+	cancel()
+	v.Close()
+	cli.Set(testKey, `{"name":"c"}`)
+	cli.Settle()
+	test.Assert(t, v.Load() == businessConfig{Workers: 4}, v.Load())
+	test.Assert(t, cli.Registered(testKey) == 0)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"sync"
+)
+
+// subscriber is a callback registered on a key, it remembers the last event it received
+// so the events are delivered in order.
+type subscriber struct {
+	callback   func(ConfigEvent, ConfigParser)
+	value      string
+	index      uint64
+	datacenter string
+	// snapshot is true if the last event is read from the local snapshot.
+	snapshot bool
+}
+
+// configWatcher holds the single consul watch of a key and fans out every
+// update to all the callbacks registered on the key.
+type configWatcher struct {
+	watchLoop
+	key string
+
+	mu          sync.Mutex
+	subscribers map[int64]*subscriber
+	latest      ConfigEvent
+	loaded      bool
+
+	// dispatchMu serializes the deliveries of the key, so the callbacks receive the events one by one.
+	dispatchMu sync.Mutex
+}
+
+func newConfigWatcher(key string) *configWatcher {
+	return &configWatcher{
+		watchLoop:   newWatchLoop(),
+		key:         key,
+		subscribers: make(map[int64]*subscriber),
+	}
+}
+
+// add registers the callback of the uniqueID and returns the number of the callbacks.
+func (w *configWatcher) add(uniqueID int64, callback func(ConfigEvent, ConfigParser)) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers[uniqueID] = &subscriber{callback: callback}
+	return len(w.subscribers)
+}
+
+// remove deregisters the callback of the uniqueID and returns the number of the remaining callbacks.
+func (w *configWatcher) remove(uniqueID int64) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subscribers, uniqueID)
+	return len(w.subscribers)
+}
+
+// load returns the latest event received by the watch, it returns false if the key
+// hasn't been received or has been deleted.
+func (w *configWatcher) load() (ConfigEvent, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.latest, w.loaded
+}
+
+// notify stores the event and delivers it to all the registered callbacks.
+// The event is dropped if it's older than the latest one of the same datacenter.
+func (w *configWatcher) notify(event ConfigEvent, parser ConfigParser) {
+	w.dispatchMu.Lock()
+	defer w.dispatchMu.Unlock()
+
+	w.mu.Lock()
+	if event.ModifyIndex != 0 && event.Datacenter == w.latest.Datacenter && event.ModifyIndex < w.latest.ModifyIndex {
+		w.mu.Unlock()
+		return
+	}
+	w.latest, w.loaded = event, !event.Deleted
+	subscribers := make([]*subscriber, 0, len(w.subscribers))
+	for _, sub := range w.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	w.mu.Unlock()
+
+	for _, sub := range subscribers {
+		w.deliver(sub, event, parser)
+	}
+}
+
+// notifyOne delivers the event to the callback of the uniqueID only, it's used for the initial value.
+func (w *configWatcher) notifyOne(uniqueID int64, event ConfigEvent, parser ConfigParser) {
+	w.dispatchMu.Lock()
+	defer w.dispatchMu.Unlock()
+
+	w.mu.Lock()
+	sub, ok := w.subscribers[uniqueID]
+	w.mu.Unlock()
+	if ok {
+		w.deliver(sub, event, parser)
+	}
+}
+
+// deliver calls the callback of the subscriber unless the subscriber has already received
+// the event or a newer one of the same datacenter. The index of a snapshot isn't compared,
+// the value from consul always replaces the snapshot. It must be called with dispatchMu held.
+func (w *configWatcher) deliver(sub *subscriber, event ConfigEvent, parser ConfigParser) {
+	if event.ModifyIndex != 0 && !sub.snapshot && event.Datacenter == sub.datacenter && event.ModifyIndex <= sub.index {
+		return
+	}
+	if sub.snapshot && event.FromSnapshot {
+		return
+	}
+	event.PrevValue = sub.value
+	sub.value, sub.index, sub.datacenter = event.Value, event.ModifyIndex, event.Datacenter
+	sub.snapshot = event.FromSnapshot
+	sub.callback(event, EventParser(parser, event))
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"testing"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestConfigWatcherFanOut(t *testing.T) {
+	w := newConfigWatcher("KitexConfig/ServiceName/limit")
+	received := map[int64]string{}
+	for _, id := range []int64{1, 2} {
+		id := id
+		w.add(id, func(event ConfigEvent, parser ConfigParser) {
+			received[id] = event.Value
+		})
+	}
+	_, loaded := w.load()
+	test.Assert(t, !loaded)
+
+	w.notify(ConfigEvent{Key: w.key, Value: `{"qps_limit":100}`, ModifyIndex: 1}, defaultConfigParse())
+	test.Assert(t, received[1] == `{"qps_limit":100}`)
+	test.Assert(t, received[2] == `{"qps_limit":100}`)
+	event, loaded := w.load()
+	test.Assert(t, loaded && event.Value == `{"qps_limit":100}`)
+
+	test.Assert(t, w.remove(1) == 1)
+	test.Assert(t, w.remove(1) == 1)
+	w.notify(ConfigEvent{Key: w.key, Value: `{}`, ModifyIndex: 2}, defaultConfigParse())
+	test.Assert(t, received[1] == `{"qps_limit":100}`)
+	test.Assert(t, received[2] == `{}`)
+
+	w.notify(ConfigEvent{Key: w.key, ModifyIndex: 3, Deleted: true}, defaultConfigParse())
+	_, loaded = w.load()
+	test.Assert(t, !loaded)
+	test.Assert(t, w.remove(2) == 0)
+}
+
+func TestConfigWatcherOrder(t *testing.T) {
+	w := newConfigWatcher("KitexConfig/ClientName/ServiceName/retry")
+	var events []ConfigEvent
+	w.add(1, func(event ConfigEvent, parser ConfigParser) {
+		events = append(events, event)
+	})
+
+	w.notify(ConfigEvent{Key: w.key, Value: "v5", ModifyIndex: 5}, defaultConfigParse())
+	// the initial value read before the watch event arrives late.
+	w.notifyOne(1, ConfigEvent{Key: w.key, Value: "v3", ModifyIndex: 3}, defaultConfigParse())
+	w.notify(ConfigEvent{Key: w.key, Value: "v4", ModifyIndex: 4}, defaultConfigParse())
+	w.notify(ConfigEvent{Key: w.key, Value: "v5", ModifyIndex: 5}, defaultConfigParse())
+	w.notify(ConfigEvent{Key: w.key, Value: "v7", ModifyIndex: 7, CreateIndex: 1, Flags: 42}, defaultConfigParse())
+
+	test.Assert(t, len(events) == 2, events)
+	test.Assert(t, events[0].Value == "v5" && events[0].PrevValue == "")
+	test.Assert(t, events[1].Value == "v7" && events[1].PrevValue == "v5")
+	test.Assert(t, events[1].CreateIndex == 1 && events[1].Flags == 42)
+
+	// a new callback receives the latest event.
+	var latest ConfigEvent
+	w.add(2, func(event ConfigEvent, parser ConfigParser) {
+		latest = event
+	})
+	event, _ := w.load()
+	w.notifyOne(2, event, defaultConfigParse())
+	test.Assert(t, latest.Value == "v7" && latest.ModifyIndex == 7)
+}
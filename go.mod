@@ -9,6 +9,7 @@ require (
 	github.com/cloudwego/kitex-examples v0.3.3
 	github.com/cloudwego/thriftgo v0.3.17
 	github.com/hashicorp/consul/api v1.26.1
+	github.com/hashicorp/hcl v1.0.0
 	go.uber.org/zap v1.26.0
 	sigs.k8s.io/yaml v1.4.0
 )
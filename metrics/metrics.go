@@ -0,0 +1,226 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics exports the config watch and apply activities as prometheus metrics.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kitex-contrib/config-consul/consul"
+)
+
+const namespace = "consul_config"
+
+var (
+	updatesDesc = prometheus.NewDesc(namespace+"_updates_total",
+		"The number of the updates and deletions of the key received from consul.", []string{"key"}, nil)
+	sinceUpdateDesc = prometheus.NewDesc(namespace+"_seconds_since_last_update",
+		"The seconds since the last update of the key was received.", []string{"key"}, nil)
+	reconnectsDesc = prometheus.NewDesc(namespace+"_watch_reconnects_total",
+		"The number of the times the watch of the key or prefix is re-established after failed queries.", []string{"key"}, nil)
+	callbacksDesc = prometheus.NewDesc(namespace+"_callbacks",
+		"The number of the callbacks registered on the key or prefix.", []string{"key"}, nil)
+	decodeFailuresDesc = prometheus.NewDesc(namespace+"_decode_failures_total",
+		"The number of the values of the key the category fails to decode.", []string{"key", "category"}, nil)
+	applyFailuresDesc = prometheus.NewDesc(namespace+"_apply_failures_total",
+		"The number of the decoded values of the key the category rejects.", []string{"key", "category"}, nil)
+	lastIndexDesc = prometheus.NewDesc(namespace+"_last_applied_index",
+		"The consul ModifyIndex of the value of the key the category applied last.", []string{"key", "category"}, nil)
+	policiesDesc = prometheus.NewDesc(namespace+"_policies",
+		"The number of the policies of the category in effect, e.g. the methods configured.", []string{"key", "category"}, nil)
+)
+
+type keyStats struct {
+	updates    uint64
+	lastUpdate time.Time
+	reconnects uint64
+	callbacks  int
+	// failing is true if the last query of the watch failed.
+	failing bool
+}
+
+type categoryKey struct {
+	key      string
+	category string
+}
+
+type categoryStats struct {
+	decodeFailures uint64
+	applyFailures  uint64
+	lastIndex      uint64
+	policies       int
+	applied        bool
+}
+
+// Collector records the activities reported to consul.Observer and exports them as prometheus
+// metrics labeled by the key and the category. Pass it to both consul.Options.Observers and
+// prometheus.Registerer.Register.
+type Collector struct {
+	consul.BaseObserver
+
+	mu         sync.Mutex
+	keys       map[string]*keyStats
+	categories map[categoryKey]*categoryStats
+	now        func() time.Time
+}
+
+var (
+	_ consul.Observer      = &Collector{}
+	_ prometheus.Collector = &Collector{}
+)
+
+// NewCollector creates an empty collector.
+func NewCollector() *Collector {
+	return &Collector{
+		keys:       make(map[string]*keyStats),
+		categories: make(map[categoryKey]*categoryStats),
+		now:        time.Now,
+	}
+}
+
+func (c *Collector) key(key string) *keyStats {
+	s, ok := c.keys[key]
+	if !ok {
+		s = &keyStats{}
+		c.keys[key] = s
+	}
+	return s
+}
+
+func (c *Collector) category(key, category string) *categoryStats {
+	k := categoryKey{key: key, category: category}
+	s, ok := c.categories[k]
+	if !ok {
+		s = &categoryStats{}
+		c.categories[k] = s
+	}
+	return s
+}
+
+// OnUpdate implements consul.Observer.
+func (c *Collector) OnUpdate(key string, _ consul.ConfigEvent) {
+	c.updated(key)
+}
+
+// OnDeleted implements consul.Observer.
+func (c *Collector) OnDeleted(key string) {
+	c.updated(key)
+}
+
+func (c *Collector) updated(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.key(key)
+	s.updates++
+	s.lastUpdate = c.now()
+}
+
+// OnFetch implements consul.Observer, the successful query after the watch errors is counted
+// as a reconnect.
+func (c *Collector) OnFetch(key string, err error) {
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.keys[key]; ok && s.failing {
+		s.failing = false
+		s.reconnects++
+	}
+}
+
+// OnWatchError implements consul.Observer.
+func (c *Collector) OnWatchError(key string, _ int, _ error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key(key).failing = true
+}
+
+// OnCallbacks implements consul.Observer, the series of the key are removed once its last
+// callback is deregistered.
+func (c *Collector) OnCallbacks(key string, callbacks int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if callbacks > 0 {
+		c.key(key).callbacks = callbacks
+		return
+	}
+	delete(c.keys, key)
+	for k := range c.categories {
+		if k.key == key {
+			delete(c.categories, k)
+		}
+	}
+}
+
+// OnDecodeError implements consul.Observer.
+func (c *Collector) OnDecodeError(key, category string, _ error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.category(key, category).decodeFailures++
+}
+
+// OnApplyError implements consul.Observer.
+func (c *Collector) OnApplyError(key, category string, _ error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.category(key, category).applyFailures++
+}
+
+// OnApplied implements consul.Observer.
+func (c *Collector) OnApplied(key, category string, index uint64, policies int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.category(key, category)
+	s.lastIndex, s.policies, s.applied = index, policies, true
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- updatesDesc
+	ch <- sinceUpdateDesc
+	ch <- reconnectsDesc
+	ch <- callbacksDesc
+	ch <- decodeFailuresDesc
+	ch <- applyFailuresDesc
+	ch <- lastIndexDesc
+	ch <- policiesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, s := range c.keys {
+		ch <- prometheus.MustNewConstMetric(updatesDesc, prometheus.CounterValue, float64(s.updates), key)
+		if !s.lastUpdate.IsZero() {
+			ch <- prometheus.MustNewConstMetric(sinceUpdateDesc, prometheus.GaugeValue, now.Sub(s.lastUpdate).Seconds(), key)
+		}
+		ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, float64(s.reconnects), key)
+		ch <- prometheus.MustNewConstMetric(callbacksDesc, prometheus.GaugeValue, float64(s.callbacks), key)
+	}
+	for k, s := range c.categories {
+		ch <- prometheus.MustNewConstMetric(decodeFailuresDesc, prometheus.CounterValue, float64(s.decodeFailures), k.key, k.category)
+		ch <- prometheus.MustNewConstMetric(applyFailuresDesc, prometheus.CounterValue, float64(s.applyFailures), k.key, k.category)
+		if s.applied {
+			ch <- prometheus.MustNewConstMetric(lastIndexDesc, prometheus.GaugeValue, float64(s.lastIndex), k.key, k.category)
+			ch <- prometheus.MustNewConstMetric(policiesDesc, prometheus.GaugeValue, float64(s.policies), k.key, k.category)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kitex-contrib/config-consul/consul"
+)
+
+const testKey = "KitexConfig/client/server/retry"
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	now := time.Unix(100, 0)
+	c.now = func() time.Time { return now }
+	registry := prometheus.NewRegistry()
+	test.Assert(t, registry.Register(c) == nil)
+
+	c.OnCallbacks(testKey, 2)
+	c.OnUpdate(testKey, consul.ConfigEvent{Key: testKey, ModifyIndex: 5})
+	c.OnApplied(testKey, "retry", 5, 3)
+	c.OnDecodeError(testKey, "retry", errors.New("bad value"))
+	c.OnApplyError(testKey, "retry", errors.New("bad policy"))
+	c.OnWatchError(testKey, 1, errors.New("unavailable"))
+	c.OnWatchError(testKey, 2, errors.New("unavailable"))
+	c.OnFetch(testKey, nil)
+	// the successful queries without the errors before aren't reconnects.
+	c.OnFetch(testKey, nil)
+	c.OnWatchError(testKey, 1, errors.New("unavailable"))
+	c.OnFetch(testKey, nil)
+	c.OnDeleted(testKey)
+	now = now.Add(30 * time.Second)
+
+	values := gather(t, registry)
+	test.Assert(t, values["consul_config_updates_total"] == 2)
+	test.Assert(t, values["consul_config_seconds_since_last_update"] == 30)
+	test.Assert(t, values["consul_config_watch_reconnects_total"] == 2)
+	test.Assert(t, values["consul_config_callbacks"] == 2)
+	test.Assert(t, values["consul_config_decode_failures_total"] == 1)
+	test.Assert(t, values["consul_config_apply_failures_total"] == 1)
+	test.Assert(t, values["consul_config_last_applied_index"] == 5)
+	test.Assert(t, values["consul_config_policies"] == 3)
+
+	c.OnCallbacks(testKey, 1)
+	test.Assert(t, gather(t, registry)["consul_config_callbacks"] == 1)
+	// the series of the key are removed with its last callback.
+	c.OnCallbacks(testKey, 0)
+	test.Assert(t, len(gather(t, registry)) == 0)
+}
+
+func TestCollectorLocatedKey(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	test.Assert(t, registry.Register(c) == nil)
+
+	// the same consul name in two partitions is watched by two keys.
+	central := consul.Key{Path: testKey, Partition: "central"}.ID()
+	edge := consul.Key{Path: testKey, Partition: "edge"}.ID()
+	for _, key := range []string{central, edge} {
+		c.OnCallbacks(key, 1)
+		c.OnUpdate(key, consul.ConfigEvent{Key: testKey, ModifyIndex: 5})
+	}
+	c.OnUpdate(central, consul.ConfigEvent{Key: testKey, ModifyIndex: 6})
+
+	families, err := registry.Gather()
+	test.Assert(t, err == nil, err)
+	updates := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "consul_config_updates_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			updates[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	test.Assert(t, len(updates) == 2 && updates[central] == 2 && updates[edge] == 1, updates)
+
+	// all the series of the located keys are removed with their last callbacks.
+	c.OnCallbacks(central, 0)
+	c.OnCallbacks(edge, 0)
+	families, err = registry.Gather()
+	test.Assert(t, err == nil, err)
+	test.Assert(t, len(families) == 0, families)
+}
+
+// gather returns the value of every metric family, the collector has a single key.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	test.Assert(t, err == nil, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		test.Assert(t, len(family.GetMetric()) == 1, family.GetName())
+		m := family.GetMetric()[0]
+		switch {
+		case m.GetCounter() != nil:
+			values[family.GetName()] = m.GetCounter().GetValue()
+		case m.GetGauge() != nil:
+			values[family.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
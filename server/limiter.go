@@ -15,6 +15,7 @@
 package server
 
 import (
+	"errors"
 	"sync/atomic"
 
 	"github.com/kitex-contrib/config-consul/consul"
@@ -28,51 +29,93 @@ import (
 
 // WithLimiter sets the limiter config from consul configuration center.
 func WithLimiter(dest string, consulClient consul.Client, uniqueID int64, opts utils.Options) server.Option {
-	param, err := consulClient.ServerConfigParam(&consul.ConfigParamConfig{
-		Category:          limiterConfigName,
-		ServerServiceName: dest,
-	})
+	param, err := configParam(limiterConfigName, dest, consulClient, opts)
 	if err != nil {
 		panic(err)
 	}
-	for _, f := range opts.ConsulCustomFunctions {
-		f(&param)
+	key := param.ID()
+	server.RegisterShutdownHook(func() {
+		consulClient.DeregisterConfig(key, uniqueID)
+	})
+	opt, err := initLimitOptions(param.Type, key, uniqueID, consulClient, opts)
+	if errors.Is(err, utils.ErrInitialConfigTimeout) {
+		klog.Warnf("[consul] %s server consul limiter: %s, continue with the default config", key, err)
+	}
+	return server.WithLimit(opt)
+}
+
+// WithLimiterE is the error-returning version of WithLimiter, the template rendering,
+// consul connectivity and initial decode errors are returned instead of panicking or logging.
+func WithLimiterE(dest string, consulClient consul.Client, uniqueID int64, opts utils.Options) (server.Option, error) {
+	param, err := configParam(limiterConfigName, dest, consulClient, opts)
+	if err != nil {
+		return server.Option{}, err
+	}
+	key := param.ID()
+	opt, err := initLimitOptions(param.Type, key, uniqueID, consulClient, opts)
+	if err != nil {
+		consulClient.DeregisterConfig(key, uniqueID)
+		return server.Option{}, err
 	}
-	key := param.Prefix + "/" + param.Path
 	server.RegisterShutdownHook(func() {
 		consulClient.DeregisterConfig(key, uniqueID)
 	})
-	return server.WithLimit(initLimitOptions(param.Type, key, uniqueID, consulClient))
+	return server.WithLimit(opt), nil
 }
 
-func initLimitOptions(kind consul.ConfigType, key string, uniqueID int64, consulClient consul.Client) *limit.Option {
+func initLimitOptions(kind consul.ConfigType, key string, uniqueID int64, consulClient consul.Client, opts utils.Options) (*limit.Option, error) {
 	var updater atomic.Value
 	opt := &limit.Option{}
+	result := &utils.InitResult{}
+	observer := consulClient.Observer()
 	opt.UpdateControl = func(u limit.Updater) {
 		klog.Debugf("[consul] %s server consul limiter updater init, config %v", key, *opt)
 		u.UpdateLimit(opt)
 		updater.Store(u)
 	}
-	onChangeCallback := func(data string, parser consul.ConfigParser) {
+	onChangeCallback := func(event consul.ConfigEvent, parser consul.ConfigParser) {
+		data := event.Value
 		lc := &limiter.LimiterConfig{}
 
-		err := parser.Decode(kind, data, lc)
-		if err != nil {
-			klog.Warnf("[consul] %s server consul limiter config: unmarshal data %s failed: %s, skip...", key, data, err)
-			return
+		if event.Deleted {
+			// the missing key is loaded with the default config.
+			result.Record(nil)
+			if opts.DeletePolicy == utils.KeepLastKnownGood {
+				klog.Infof("[consul] %s server consul limiter config: key deleted, keep the last config", key)
+				return
+			}
+			// the empty config goes back to the initial options.
+		} else {
+			err := parser.Decode(kind, data, lc)
+			result.Record(err)
+			if err != nil {
+				klog.Warnf("[consul] %s server consul limiter config: unmarshal data %s failed: %s, skip...", key, data, err)
+				observer.OnDecodeError(key, limiterConfigName, err)
+				return
+			}
 		}
 
 		opt.MaxConnections = int(lc.ConnectionLimit)
 		opt.MaxQPS = int(lc.QPSLimit)
+		policies := 0
+		if opt.MaxConnections > 0 {
+			policies++
+		}
+		if opt.MaxQPS > 0 {
+			policies++
+		}
 		u := updater.Load()
 		if u == nil {
+			// the options are applied by UpdateControl when the server starts.
 			klog.Warnf("[consul] %s server consul limiter config failed as the updater is empty", key)
+			observer.OnApplied(key, limiterConfigName, event.ModifyIndex, policies)
 			return
 		}
 		if !u.(limit.Updater).UpdateLimit(opt) {
 			klog.Warnf("[consul] %s server consul limiter config: data %s may do not take affect", key, data)
 		}
+		observer.OnApplied(key, limiterConfigName, event.ModifyIndex, policies)
 	}
-	consulClient.RegisterConfigCallback(key, uniqueID, onChangeCallback)
-	return opt
+	err := consulClient.RegisterConfigEventCallback(key, uniqueID, onChangeCallback)
+	return opt, utils.InitError(key, result, err, opts)
 }
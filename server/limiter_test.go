@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	kitexutils "github.com/cloudwego/kitex/pkg/utils"
+	"github.com/cloudwego/kitex/server"
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+	"github.com/kitex-contrib/config-consul/utils"
+)
+
+func TestLimiterE(t *testing.T) {
+	cli, err := consultest.NewClient(consul.Options{})
+	test.Assert(t, err == nil)
+
+	cli.Set("KitexConfig/ServiceName/limit", `{"connection_limit":100,"qps_limit":2000}`)
+	_, err = WithLimiterE("ServiceName", cli, 1, utils.Options{})
+	test.Assert(t, err == nil)
+	test.Assert(t, cli.Registered("KitexConfig/ServiceName/limit") == 1)
+
+	// the callback is deregistered if the initial config is invalid.
+	cli.Set("KitexConfig/Invalid/limit", "invalid")
+	_, err = WithLimiterE("Invalid", cli, 2, utils.Options{})
+	test.Assert(t, err != nil)
+	test.Assert(t, cli.Registered("KitexConfig/Invalid/limit") == 0)
+}
+
+func TestSuiteBasicInfo(t *testing.T) {
+	cli, err := consultest.NewClient(consul.Options{ServerPathFormat: "{{.Env}}/{{.ServerServiceName}}/{{.Method}}/{{.Category}}"})
+	test.Assert(t, err == nil)
+
+	// the dimensions not set by the options are filled from the server basic info.
+	suite := NewSuite("ServiceName", cli, utils.WithMethod("Echo"))
+	o := &server.Options{Svr: &rpcinfo.EndpointBasicInfo{ServiceName: "ServiceName", Tags: map[string]string{utils.TagEnv: "prod"}}}
+	for _, opt := range suite.Options() {
+		opt.F(o, &kitexutils.Slice{})
+	}
+	test.Assert(t, cli.Registered("KitexConfig/prod/ServiceName/Echo/limit") == 1)
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/kitex-contrib/config-consul/consul"
 	"github.com/kitex-contrib/config-consul/utils"
 
+	kitexutils "github.com/cloudwego/kitex/pkg/utils"
 	"github.com/cloudwego/kitex/server"
 )
 
@@ -31,6 +32,8 @@ type ConsulServerSuite struct {
 	consulClient consul.Client
 	service      string
 	opts         utils.Options
+	// options is built eagerly by NewSuiteE.
+	options []server.Option
 }
 
 // NewSuite service is the destination service.
@@ -49,9 +52,57 @@ func NewSuite(service string, cli consul.Client,
 	return su
 }
 
-// Options return a list server.Option
+// NewSuiteE is the error-returning version of NewSuite, the options are built eagerly so
+// the template rendering, consul connectivity and initial decode errors are returned here.
+func NewSuiteE(service string, cli consul.Client,
+	opts ...utils.Option,
+) (*ConsulServerSuite, error) {
+	su := NewSuite(service, cli, opts...)
+	options, err := su.OptionsE()
+	if err != nil {
+		return nil, err
+	}
+	su.options = options
+	return su, nil
+}
+
+// Options return a list server.Option. The dimensions of the keys that aren't set by the options
+// are filled from the basic info of the server, e.g. set by server.WithServerBasicInfo before the suite.
 func (s *ConsulServerSuite) Options() []server.Option {
-	opts := make([]server.Option, 0, 2)
-	opts = append(opts, WithLimiter(s.service, s.consulClient, s.uid, s.opts))
-	return opts
+	if s.options != nil {
+		return s.options
+	}
+	return []server.Option{{F: func(o *server.Options, di *kitexutils.Slice) {
+		s.opts = s.opts.FillEndpointBasicInfo(o.Svr)
+		WithLimiter(s.service, s.consulClient, s.uid, s.opts).F(o, di)
+	}}}
+}
+
+// OptionsE is the error-returning version of Options.
+func (s *ConsulServerSuite) OptionsE() ([]server.Option, error) {
+	if s.options != nil {
+		return s.options, nil
+	}
+	limiter, err := WithLimiterE(s.service, s.consulClient, s.uid, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	return []server.Option{limiter}, nil
+}
+
+// configParam renders the consul key of the category of the service.
+func configParam(category, dest string, consulClient consul.Client, opts utils.Options) (consul.Key, error) {
+	cpc := opts.ConfigParamConfig(category, dest, "")
+	param, err := consulClient.ServerConfigParam(cpc)
+	if err != nil {
+		return param, err
+	}
+	if param.Fallbacks, err = opts.FallbackKeys(param.Prefix, cpc); err != nil {
+		return param, err
+	}
+	for _, f := range opts.ConsulCustomFunctions {
+		f(&param)
+	}
+	consulClient.DeclareKey(param)
+	return param, nil
 }
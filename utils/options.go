@@ -14,7 +14,43 @@
 
 package utils
 
-import "github.com/kitex-contrib/config-consul/consul"
+import (
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+
+	"github.com/kitex-contrib/config-consul/consul"
+)
+
+// TimeoutPolicy decides what to do when the initial config isn't applied before the deadline.
+type TimeoutPolicy int
+
+const (
+	// ContinueWithDefaults logs the timeout and goes on with the kitex default config.
+	ContinueWithDefaults TimeoutPolicy = iota
+	// FailFast returns the timeout error from the E variants, the others log it and continue
+	// with the kitex default config.
+	FailFast
+)
+
+// DeletePolicy decides how the categories react when their consul key is deleted.
+type DeletePolicy int
+
+const (
+	// RevertToDefaults drops the config of the deleted key and goes back to the kitex default config.
+	RevertToDefaults DeletePolicy = iota
+	// KeepLastKnownGood keeps the last config applied before the key is deleted.
+	KeepLastKnownGood
+)
+
+// The tags of the kitex endpoint info read by WithEndpointBasicInfo.
+const (
+	TagEnv     = "env"
+	TagCluster = "cluster"
+	TagRegion  = "region"
+	TagZone    = "zone"
+	TagIDC     = "idc"
+)
 
 // Option is used to custom Options.
 type Option interface {
@@ -24,4 +60,200 @@ type Option interface {
 // Options is used to initialize the nacos config suit or option.
 type Options struct {
 	ConsulCustomFunctions []consul.CustomFunction
+	// InitialConfigTimeout is how long the suites block until the config of every category
+	// is loaded and decoded, waiting is disabled if it's not positive.
+	InitialConfigTimeout time.Duration
+	TimeoutPolicy        TimeoutPolicy
+	DeletePolicy         DeletePolicy
+	// Env, Cluster, Region, Zone and Tags are passed to the path templates of the keys.
+	Env     string
+	Cluster string
+	Region  string
+	Zone    string
+	Tags    map[string]string
+	// Method scopes the keys to a method, see WithMethod.
+	Method string
+	// FallbackPaths are the path templates of the lower layers of the keys, see WithFallbackPaths.
+	FallbackPaths []string
+}
+
+// ConfigParamConfig returns the template params of the key of the category, the deployment
+// dimensions are filled from the options.
+func (o Options) ConfigParamConfig(category, server, client string) *consul.ConfigParamConfig {
+	tags := make(map[string]string, len(o.Tags))
+	for k, v := range o.Tags {
+		tags[k] = v
+	}
+	return &consul.ConfigParamConfig{
+		Category:          category,
+		ServerServiceName: server,
+		ClientServiceName: client,
+		Env:               o.Env,
+		Cluster:           o.Cluster,
+		Region:            o.Region,
+		Zone:              o.Zone,
+		Method:            o.Method,
+		Tags:              tags,
+	}
+}
+
+// Remaining returns a copy of the options whose InitialConfigTimeout is the time left until
+// the deadline, so several categories can share one deadline.
+func (o Options) Remaining(deadline time.Time) Options {
+	if o.InitialConfigTimeout <= 0 {
+		return o
+	}
+	o.InitialConfigTimeout = time.Until(deadline)
+	if o.InitialConfigTimeout <= 0 {
+		// the deadline has passed, only check whether the config is applied.
+		o.InitialConfigTimeout = time.Nanosecond
+	}
+	return o
+}
+
+type option func(*Options)
+
+func (o option) Apply(opts *Options) {
+	o(opts)
+}
+
+// WithWaitInitialConfig blocks the suites until the config of every category is loaded
+// and decoded or the timeout passes, the policy decides what to do on timeout.
+func WithWaitInitialConfig(timeout time.Duration, policy TimeoutPolicy) Option {
+	return option(func(opts *Options) {
+		opts.InitialConfigTimeout = timeout
+		opts.TimeoutPolicy = policy
+	})
+}
+
+// FallbackKeys renders the fallback paths with the params under the prefix, the paths are rendered
+// by consul.RenderPath like the keys of the categories.
+func (o Options) FallbackKeys(prefix string, cpc *consul.ConfigParamConfig) ([]string, error) {
+	keys := make([]string, 0, len(o.FallbackPaths))
+	for _, format := range o.FallbackPaths {
+		path, err := consul.RenderPath(format, cpc)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, prefix+"/"+path)
+	}
+	return keys, nil
+}
+
+// WithFallbackPaths sets the path templates of the lower layers of the keys of the categories, from
+// the lowest priority. They're rendered like the ServerPathFormat and ClientPathFormat of the consul
+// client under the same prefix, e.g. "*/{{.ServerServiceName}}/{{.Category}}" for the defaults shared
+// by all the callers. The layers are watched together with the key of the category and deep-merged,
+// the key of the category is the top layer.
+func WithFallbackPaths(formats ...string) Option {
+	return option(func(opts *Options) {
+		opts.FallbackPaths = formats
+	})
+}
+
+// WithEnv sets the environment of the service, e.g. staging or production.
+func WithEnv(env string) Option {
+	return option(func(opts *Options) {
+		opts.Env = env
+	})
+}
+
+// WithCluster sets the cluster of the service.
+func WithCluster(cluster string) Option {
+	return option(func(opts *Options) {
+		opts.Cluster = cluster
+	})
+}
+
+// WithRegion sets the region of the service.
+func WithRegion(region string) Option {
+	return option(func(opts *Options) {
+		opts.Region = region
+	})
+}
+
+// WithZone sets the zone of the service.
+func WithZone(zone string) Option {
+	return option(func(opts *Options) {
+		opts.Zone = zone
+	})
+}
+
+// WithMethod scopes the keys of the categories to the method, it's rendered by {{.Method}} in the
+// path templates, e.g. "{{.ServerServiceName}}/{{.Method}}/{{.Category}}" for the method-level keys.
+func WithMethod(method string) Option {
+	return option(func(opts *Options) {
+		opts.Method = method
+	})
+}
+
+// WithTag adds a free-form tag passed to the path templates.
+func WithTag(key, value string) Option {
+	return option(func(opts *Options) {
+		if opts.Tags == nil {
+			opts.Tags = make(map[string]string)
+		}
+		opts.Tags[key] = value
+	})
+}
+
+// WithEndpointBasicInfo fills the dimensions from the kitex endpoint info, e.g. the one passed to
+// client.WithClientBasicInfo or server.WithServerBasicInfo. The tags of the info are added, and the
+// tags "env", "cluster", "region" and "zone" ("idc" as well) set the dimensions that are not set yet,
+// so does the method of the info.
+func WithEndpointBasicInfo(info *rpcinfo.EndpointBasicInfo) Option {
+	return option(func(opts *Options) {
+		if info == nil {
+			return
+		}
+		for k, v := range info.Tags {
+			WithTag(k, v).Apply(opts)
+		}
+		setDefault := func(dst *string, tags ...string) {
+			for _, tag := range tags {
+				if *dst == "" {
+					*dst = info.Tags[tag]
+				}
+			}
+		}
+		setDefault(&opts.Env, TagEnv)
+		setDefault(&opts.Cluster, TagCluster)
+		setDefault(&opts.Region, TagRegion)
+		setDefault(&opts.Zone, TagZone, TagIDC)
+		if opts.Method == "" {
+			opts.Method = info.Method
+		}
+	})
+}
+
+// FillEndpointBasicInfo returns a copy of the options whose dimensions not set yet are filled from
+// the kitex endpoint infos like WithEndpointBasicInfo, the suites use it to fill the dimensions from
+// the basic info of the client or server by default. The earlier infos and the tags set explicitly
+// take precedence.
+func (o Options) FillEndpointBasicInfo(infos ...*rpcinfo.EndpointBasicInfo) Options {
+	tags := make(map[string]string, len(o.Tags))
+	for i := len(infos) - 1; i >= 0; i-- {
+		if infos[i] != nil {
+			for k, v := range infos[i].Tags {
+				tags[k] = v
+			}
+		}
+	}
+	for k, v := range o.Tags {
+		tags[k] = v
+	}
+	// the tags of the options aren't modified, they're shared with the suite.
+	o.Tags = nil
+	for _, info := range infos {
+		WithEndpointBasicInfo(info).Apply(&o)
+	}
+	o.Tags = tags
+	return o
+}
+
+// WithDeletePolicy sets how the categories react when their consul key is deleted.
+func WithDeletePolicy(policy DeletePolicy) Option {
+	return option(func(opts *Options) {
+		opts.DeletePolicy = policy
+	})
 }
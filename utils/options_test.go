@@ -0,0 +1,85 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/cloudwego/thriftgo/pkg/test"
+
+	"github.com/kitex-contrib/config-consul/consul"
+	"github.com/kitex-contrib/config-consul/consul/consultest"
+)
+
+func TestConfigParamConfig(t *testing.T) {
+	opts := Options{}
+	for _, opt := range []Option{
+		WithEnv("staging"),
+		WithEndpointBasicInfo(&rpcinfo.EndpointBasicInfo{
+			ServiceName: "ServiceName",
+			Tags:        map[string]string{TagEnv: "prod", TagCluster: "c1", TagIDC: "hl", "team": "infra"},
+		}),
+		WithRegion("cn"),
+	} {
+		opt.Apply(&opts)
+	}
+	cpc := opts.ConfigParamConfig("retry", "ServiceName", "ClientName")
+	// the explicit env isn't replaced by the tag.
+	test.Assert(t, cpc.Env == "staging" && cpc.Cluster == "c1" && cpc.Region == "cn" && cpc.Zone == "hl", cpc)
+	test.Assert(t, cpc.Tags["team"] == "infra")
+
+	cli, err := consultest.NewClient(consul.Options{
+		ClientPathFormat: `{{.Env}}/{{.Cluster}}/{{index .Tags "team"}}/{{.ServerServiceName}}/{{.Category}}`,
+	})
+	test.Assert(t, err == nil)
+	key, err := cli.ClientConfigParam(cpc)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, key.Path == "staging/c1/infra/ServiceName/retry", key.Path)
+
+	opts.FallbackPaths = []string{"*/{{.ServerServiceName}}/{{.Category}}"}
+	fallbacks, err := opts.FallbackKeys(key.Prefix, cpc)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, len(fallbacks) == 1 && fallbacks[0] == "KitexConfig/*/ServiceName/retry", fallbacks)
+
+	// the special characters are escaped in the same way as the key.
+	cpc.Tags["team"] = "r&d"
+	key, err = cli.ClientConfigParam(cpc)
+	test.Assert(t, err == nil, err)
+	opts.FallbackPaths = []string{`{{.Env}}/{{.Cluster}}/{{index .Tags "team"}}/{{.ServerServiceName}}/{{.Category}}`}
+	fallbacks, err = opts.FallbackKeys(key.Prefix, cpc)
+	test.Assert(t, err == nil, err)
+	test.Assert(t, fallbacks[0] == key.Prefix+"/"+key.Path, fallbacks, key)
+}
+
+func TestFillEndpointBasicInfo(t *testing.T) {
+	opts := Options{}
+	for _, opt := range []Option{WithZone("z1"), WithTag("team", "infra"), WithMethod("Echo")} {
+		opt.Apply(&opts)
+	}
+	filled := opts.FillEndpointBasicInfo(
+		&rpcinfo.EndpointBasicInfo{Method: "Other", Tags: map[string]string{TagEnv: "prod", TagIDC: "hl", "team": "client"}},
+		nil,
+		&rpcinfo.EndpointBasicInfo{Tags: map[string]string{TagEnv: "staging", TagCluster: "c1", "owner": "server"}},
+	)
+	// the dimensions and tags set explicitly and the ones of the earlier infos take precedence.
+	test.Assert(t, filled.Env == "prod" && filled.Cluster == "c1" && filled.Zone == "z1" && filled.Method == "Echo", filled)
+	test.Assert(t, filled.Tags["team"] == "infra" && filled.Tags["owner"] == "server" && filled.Tags[TagEnv] == "prod", filled.Tags)
+	// the options aren't modified.
+	test.Assert(t, opts.Env == "" && len(opts.Tags) == 1, opts)
+
+	cpc := Options{}.FillEndpointBasicInfo(&rpcinfo.EndpointBasicInfo{Method: "Echo"}).ConfigParamConfig("retry", "ServiceName", "ClientName")
+	test.Assert(t, cpc.Method == "Echo", cpc)
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// ErrInitialConfigTimeout is returned when the initial config isn't applied before the deadline.
+var ErrInitialConfigTimeout = errors.New("wait initial config timeout")
+
+// InitResult records the result of the first config applied by a category.
+type InitResult struct {
+	mu    sync.Mutex
+	set   bool
+	err   error
+	ready chan struct{}
+}
+
+// Record keeps the result of the first call and ignores the later ones, the result
+// becomes ready once a config is applied successfully.
+func (r *InitResult) Record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.readyChan()
+		select {
+		case <-r.ready:
+		default:
+			close(r.ready)
+		}
+	}
+	if r.set {
+		return
+	}
+	r.set = true
+	r.err = err
+}
+
+// Err returns the error of the first config applied, nil if it's applied successfully or not received yet.
+func (r *InitResult) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Wait blocks until a config is applied successfully or the timeout passes, it returns false on timeout.
+func (r *InitResult) Wait(timeout time.Duration) bool {
+	r.mu.Lock()
+	ready := r.readyChan()
+	r.mu.Unlock()
+	select {
+	case <-ready:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// readyChan must be called with the lock held.
+func (r *InitResult) readyChan() chan struct{} {
+	if r.ready == nil {
+		r.ready = make(chan struct{})
+	}
+	return r.ready
+}
+
+// InitError returns the error of the initial config of the key. If waiting for the initial config is
+// enabled in opts, it blocks until the config is applied or the timeout passes, then the timeout is
+// handled by the TimeoutPolicy.
+func InitError(key string, result *InitResult, registerErr error, opts Options) error {
+	if opts.InitialConfigTimeout <= 0 {
+		if registerErr != nil {
+			return registerErr
+		}
+		return result.Err()
+	}
+	if result.Wait(opts.InitialConfigTimeout) {
+		return nil
+	}
+	cause := registerErr
+	if cause == nil {
+		cause = result.Err()
+	}
+	if opts.TimeoutPolicy == FailFast {
+		err := fmt.Errorf("%w: key %s", ErrInitialConfigTimeout, key)
+		if cause != nil {
+			err = fmt.Errorf("%w: %s", err, cause)
+		}
+		return err
+	}
+	klog.Warnf("[consul] %s wait initial config timeout, continue with the default config, cause: %v", key, cause)
+	return nil
+}
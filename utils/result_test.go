@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/thriftgo/pkg/test"
+)
+
+func TestInitResult(t *testing.T) {
+	errDecode := errors.New("decode failed")
+	result := &InitResult{}
+	test.Assert(t, !result.Wait(time.Millisecond))
+
+	result.Record(errDecode)
+	test.Assert(t, errors.Is(result.Err(), errDecode))
+	test.Assert(t, !result.Wait(time.Millisecond))
+
+	go result.Record(nil)
+	test.Assert(t, result.Wait(time.Second))
+	// the first result is kept.
+	test.Assert(t, errors.Is(result.Err(), errDecode))
+}
+
+func TestInitError(t *testing.T) {
+	errConsul := errors.New("consul unreachable")
+	key := "KitexConfig/ClientName/ServiceName/retry"
+
+	// waiting is disabled.
+	test.Assert(t, errors.Is(InitError(key, &InitResult{}, errConsul, Options{}), errConsul))
+	test.Assert(t, InitError(key, &InitResult{}, nil, Options{}) == nil)
+
+	opts := Options{}
+	WithWaitInitialConfig(time.Millisecond, ContinueWithDefaults).Apply(&opts)
+	test.Assert(t, InitError(key, &InitResult{}, errConsul, opts) == nil)
+
+	WithWaitInitialConfig(time.Millisecond, FailFast).Apply(&opts)
+	err := InitError(key, &InitResult{}, errConsul, opts)
+	test.Assert(t, errors.Is(err, ErrInitialConfigTimeout), err)
+
+	applied := &InitResult{}
+	applied.Record(nil)
+	test.Assert(t, InitError(key, applied, nil, opts.Remaining(time.Now().Add(-time.Second))) == nil)
+}